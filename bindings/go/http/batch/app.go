@@ -18,6 +18,7 @@ dapr run --app-id batch-http --app-port 6003 --dapr-http-port 3503 --dapr-grpc-p
 */
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -98,12 +99,27 @@ func sqlOutput(order Order) (err error) {
 	if err != nil {
 		return err
 	}
+	if token := os.Getenv("DAPR_API_TOKEN"); token != "" {
+		req.Header.Set("dapr-api-token", token)
+	}
 	if _, err = client.Do(req); err != nil {
 		return err
 	}
 	return nil
 }
 
+// requireAppToken only lets the sidecar trigger the batch when APP_API_TOKEN is set
+func requireAppToken(next http.Handler) http.Handler {
+	token := os.Getenv("APP_API_TOKEN")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("dapr-api-token")), []byte(token)) != 1 {
+			http.Error(w, "missing or invalid dapr-api-token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func main() {
 	var appPort string
 	var okHost bool
@@ -112,6 +128,7 @@ func main() {
 	}
 
 	r := mux.NewRouter()
+	r.Use(requireAppToken)
 
 	// Triggered by Dapr input binding
 	r.HandleFunc("/"+cronBindingName, processBatch).Methods("POST")
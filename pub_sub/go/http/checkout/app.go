@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 )
 
 const pubsubComponentName = "orderpubsub"
 const pubsubTopic = "orders"
 
+var (
+	pubsubFlag = flag.String("pubsub", pubsubComponentName, "name of the pub/sub component to publish to")
+	topicFlag  = flag.String("topic", pubsubTopic, "topic to publish to")
+	rateFlag   = flag.Float64("rate", 1, "largest number of orders published per second; unlimited when 0")
+)
+
 func main() {
+	flag.Parse()
+
 	daprHost := os.Getenv("DAPR_HOST")
 	if daprHost == "" {
 		daprHost = "http://localhost"
@@ -23,28 +32,36 @@ func main() {
 		daprHttpPort = "3500"
 	}
 
+	daprApiToken := os.Getenv("DAPR_API_TOKEN")
+
 	client := http.Client{
 		Timeout: 15 * time.Second,
 	}
 
-	for i := 1; i <= 10; i++ {
-		order := `{"orderId":` + strconv.Itoa(i) + `}`
-		req, err := http.NewRequest("POST", daprHost+":"+daprHttpPort+"/v1.0/publish/"+pubsubComponentName+"/"+pubsubTopic, strings.NewReader(order))
+	orders := streamOrders(*rateFlag, 10, func(i int) []byte {
+		return []byte(`{"orderId":` + strconv.Itoa(i) + `}`)
+	})
+	for order := range orders {
+		req, err := http.NewRequest("POST", daprHost+":"+daprHttpPort+"/v1.0/publish/"+*pubsubFlag+"/"+*topicFlag, bytes.NewReader(order))
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
 		req.Header.Set("Content-Type", "application/json")
+		if daprApiToken != "" {
+			req.Header.Set("dapr-api-token", daprApiToken)
+		}
 
 		// Publish an event using Dapr pub/sub
 		res, err := client.Do(req)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer res.Body.Close()
-
-		fmt.Println("Published data:", order)
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			log.Fatalf("Failed to publish %s: %s", order, res.Status)
+		}
 
-		time.Sleep(time.Second)
+		fmt.Println("Published data:", string(order))
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,6 +30,12 @@ func getOrder(w http.ResponseWriter, r *http.Request) {
 			Topic:      "orders",
 			Route:      "orders",
 		},
+		{
+			// The state_management order-processor publishes this event through the outbox of its state store
+			PubsubName: "orderpubsub",
+			Topic:      "order-saved",
+			Route:      "order-saved",
+		},
 	}
 	jsonBytes, err := json.Marshal(jsonData)
 	if err != nil {
@@ -64,6 +71,35 @@ func postOrder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type OrderSavedEvent struct {
+	Data json.RawMessage `json:"data"`
+}
+
+func postOrderSaved(w http.ResponseWriter, r *http.Request) {
+	var event OrderSavedEvent
+	err := json.NewDecoder(r.Body).Decode(&event)
+	if err != nil {
+		log.Println("Invalid order saved event:", err.Error())
+		// Drop the event instead of retrying it, since it can never be decoded
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	fmt.Println("Order saved event received:", string(event.Data))
+	w.WriteHeader(http.StatusOK)
+}
+
+// requireAppToken only accepts the events and subscription requests of the sidecar when APP_API_TOKEN is set
+func requireAppToken(next http.Handler) http.Handler {
+	token := os.Getenv("APP_API_TOKEN")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("dapr-api-token")), []byte(token)) != 1 {
+			http.Error(w, "missing or invalid dapr-api-token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func main() {
 	appPort := os.Getenv("APP_PORT")
 	if appPort == "" {
@@ -71,12 +107,14 @@ func main() {
 	}
 
 	r := mux.NewRouter()
+	r.Use(requireAppToken)
 
 	// Handle the /dapr/subscribe route which Dapr invokes to get the list of subscribed endpoints
 	r.HandleFunc("/dapr/subscribe", getOrder).Methods("GET")
 
 	// Dapr subscription routes orders topic to this route
 	r.HandleFunc("/orders", postOrder).Methods("POST")
+	r.HandleFunc("/order-saved", postOrderSaved).Methods("POST")
 
 	// Start the server; this is a blocking call
 	err := http.ListenAndServe(":"+appPort, r)
@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"strconv"
-	"time"
 
 	dapr "github.com/dapr/go-sdk/client"
 )
@@ -14,7 +14,15 @@ const (
 	pubsubTopic         = "orders"
 )
 
+var (
+	pubsubFlag = flag.String("pubsub", pubsubComponentName, "name of the pub/sub component to publish to")
+	topicFlag  = flag.String("topic", pubsubTopic, "topic to publish to")
+	rateFlag   = flag.Float64("rate", 1, "largest number of orders published per second; unlimited when 0")
+)
+
 func main() {
+	flag.Parse()
+
 	// Create a new client for Dapr using the SDK
 	client, err := dapr.NewClient()
 	if err != nil {
@@ -23,16 +31,15 @@ func main() {
 	defer client.Close()
 
 	// Publish events using Dapr pubsub
-	for i := 1; i <= 10; i++ {
-		order := `{"orderId":` + strconv.Itoa(i) + `}`
-
-		err := client.PublishEvent(context.Background(), pubsubComponentName, pubsubTopic, []byte(order))
+	orders := streamOrders(*rateFlag, 10, func(i int) []byte {
+		return []byte(`{"orderId":` + strconv.Itoa(i) + `}`)
+	})
+	for order := range orders {
+		err := client.PublishEvent(context.Background(), *pubsubFlag, *topicFlag, order)
 		if err != nil {
 			panic(err)
 		}
 
-		fmt.Println("Published data:", order)
-
-		time.Sleep(time.Second)
+		fmt.Println("Published data:", string(order))
 	}
 }
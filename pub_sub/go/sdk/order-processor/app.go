@@ -17,6 +17,13 @@ var sub = &common.Subscription{
 	Route:      "/orders",
 }
 
+// The state_management order-processor publishes this event through the outbox of its state store
+var orderSavedSub = &common.Subscription{
+	PubsubName: "orderpubsub",
+	Topic:      "order-saved",
+	Route:      "/order-saved",
+}
+
 func main() {
 	appPort := os.Getenv("APP_PORT")
 	if appPort == "" {
@@ -29,6 +36,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("error adding topic subscription: %v", err)
 	}
+	err = s.AddTopicEventHandler(orderSavedSub, orderSavedHandler)
+	if err != nil {
+		log.Fatalf("error adding topic subscription: %v", err)
+	}
 
 	// Start the server
 	err = s.Start()
@@ -41,3 +52,8 @@ func eventHandler(ctx context.Context, e *common.TopicEvent) (retry bool, err er
 	fmt.Println("Subscriber received:", e.Data)
 	return false, nil
 }
+
+func orderSavedHandler(ctx context.Context, e *common.TopicEvent) (retry bool, err error) {
+	fmt.Println("Order saved event received:", string(e.RawData))
+	return false, nil
+}
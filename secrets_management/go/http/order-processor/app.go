@@ -20,7 +20,15 @@ func main() {
 	const DAPR_SECRET_STORE = "localsecretstore"
 	const SECRET_NAME = "secret"
 	// Get secret from a local secret store
-	getResponse, err := http.Get(DAPR_HOST + ":" + DAPR_HTTP_PORT + "/v1.0/secrets/" + DAPR_SECRET_STORE + "/" + SECRET_NAME)
+	req, err := http.NewRequest("GET", DAPR_HOST+":"+DAPR_HTTP_PORT+"/v1.0/secrets/"+DAPR_SECRET_STORE+"/"+SECRET_NAME, nil)
+	if err != nil {
+		fmt.Print(err.Error())
+		os.Exit(1)
+	}
+	if DAPR_API_TOKEN := os.Getenv("DAPR_API_TOKEN"); DAPR_API_TOKEN != "" {
+		req.Header.Set("dapr-api-token", DAPR_API_TOKEN)
+	}
+	getResponse, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Print(err.Error())
 		os.Exit(1)
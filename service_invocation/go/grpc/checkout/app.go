@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dapr/quickstarts/service_invocation/go/grpc/orders"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var (
+	appIdFlag = flag.String("app-id", "order-processor", "app ID of the service to invoke")
+	rateFlag  = flag.Float64("rate", 0, "largest number of orders sent per second; unlimited when 0")
+)
+
+func main() {
+	flag.Parse()
+
+	daprGrpcPort := os.Getenv("DAPR_GRPC_PORT")
+	if daprGrpcPort == "" {
+		daprGrpcPort = "50001"
+	}
+
+	// Connect to the Dapr sidecar, which proxies the calls to the app named in the dapr-app-id metadata
+	conn, err := grpc.Dial("localhost:"+daprGrpcPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := orders.NewOrderServiceClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "dapr-app-id", *appIdFlag)
+	if daprApiToken := os.Getenv("DAPR_API_TOKEN"); daprApiToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "dapr-api-token", daprApiToken)
+	}
+
+	// Each record is converted to an Order message; fields that the message doesn't have are ignored
+	unmarshal := protojson.UnmarshalOptions{DiscardUnknown: true}
+	records := streamOrders(*rateFlag, 20, func(i int) []byte {
+		return []byte(`{"orderId":` + strconv.Itoa(i) + "}")
+	})
+	for record := range records {
+		var order orders.Order
+		err = unmarshal.Unmarshal(record, &order)
+		if err != nil {
+			log.Fatalf("Invalid order %s: %v", record, err)
+		}
+
+		// Invoking a service
+		callCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		result, err := client.PlaceOrder(callCtx, &order)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Order passed:", result.GetOrderId())
+	}
+}
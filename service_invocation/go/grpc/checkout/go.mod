@@ -0,0 +1,19 @@
+module checkout_grpc_example
+
+go 1.21
+
+require (
+	github.com/dapr/quickstarts/service_invocation/go/grpc/orders v0.0.0
+	google.golang.org/grpc v1.62.0
+	google.golang.org/protobuf v1.33.0
+)
+
+replace github.com/dapr/quickstarts/service_invocation/go/grpc/orders => ../orders
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240205150955-31a09d347014 // indirect
+)
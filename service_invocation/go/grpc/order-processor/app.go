@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/dapr/quickstarts/service_invocation/go/grpc/orders"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// orderService implements the OrderService defined in orders.proto
+type orderService struct {
+	orders.UnimplementedOrderServiceServer
+}
+
+func (s *orderService) PlaceOrder(ctx context.Context, order *orders.Order) (*orders.Order, error) {
+	fmt.Println("Order received:", order.GetOrderId())
+	return order, nil
+}
+
+// requireAppToken checks the dapr-api-token metadata of each call when a token is set. The go-sdk only checks
+// APP_API_TOKEN in its own callback handlers, not in the services registered on a plain gRPC server.
+func requireAppToken(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if token != "" {
+			md, _ := metadata.FromIncomingContext(ctx)
+			values := md.Get("dapr-api-token")
+			if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) != 1 {
+				return nil, status.Error(codes.Unauthenticated, "missing or invalid dapr-api-token")
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func main() {
+	appPort := os.Getenv("APP_PORT")
+	if appPort == "" {
+		appPort = "6007"
+	}
+
+	lis, err := net.Listen("tcp", ":"+appPort)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	// Create a gRPC server and register the OrderService; Dapr proxies the calls of other apps to it
+	s := grpc.NewServer(grpc.UnaryInterceptor(requireAppToken(os.Getenv("APP_API_TOKEN"))))
+	orders.RegisterOrderServiceServer(s, &orderService{})
+
+	// Start the server listening on appPort
+	// This is a blocking call
+	fmt.Println("Order service listening on port", appPort)
+	err = s.Serve(lis)
+	if err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
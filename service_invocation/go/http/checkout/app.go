@@ -1,6 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -8,10 +15,56 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// rejectedError is returned when the order processor rejects an order as invalid; retrying it can't succeed
+type rejectedError struct {
+	problem Problem
+}
+
+func (e *rejectedError) Error() string {
+	return e.problem.String()
+}
+
+// permanentError is returned for the responses that retrying won't change
+type permanentError struct {
+	status string
+	body   string
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("unexpected response status %s: %s", e.status, e.body)
+}
+
+var (
+	appIdFlag  = flag.String("app-id", "order-processor", "app ID of the service to invoke")
+	methodFlag = flag.String("method", "orders", "method of the service to invoke")
+	// The order-router app routes the requests to the version in the X-Order-Version header
+	versionFlag = flag.String("version", "", "version of the order processor to request, when invoking the order-router")
+	rateFlag    = flag.Float64("rate", 0, "largest number of orders sent per second; unlimited when 0")
+)
+
+// checkout submits orders to the order processor, retrying the attempts that fail with a backoff and
+// stopping the calls with a circuit breaker while the order processor keeps failing
+type checkout struct {
+	client  *http.Client
+	url     string
+	appId   string
+	version string
+	token   string
+	timeout time.Duration
+	backoff backoff
+	breaker *circuitBreaker
+
+	passed, rejected, failed, retries, shortCircuits atomic.Int64
+}
+
 func main() {
+	flag.Parse()
+
 	daprHost := os.Getenv("DAPR_HOST")
 	if daprHost == "" {
 		daprHost = "http://localhost"
@@ -20,32 +73,212 @@ func main() {
 	if daprHttpPort == "" {
 		daprHttpPort = "3500"
 	}
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+
+	// By default orders are retried forever like with the retryForever policy of resiliency.yaml, but with
+	// exponential backoff instead of its constant 5s interval; the breaker defaults match simpleCB
+	c := &checkout{
+		client:  &http.Client{},
+		url:     daprHost + ":" + daprHttpPort + "/" + *methodFlag,
+		appId:   *appIdFlag,
+		version: *versionFlag,
+		token:   os.Getenv("DAPR_API_TOKEN"),
+		timeout: time.Duration(getEnvInt("REQUEST_TIMEOUT_SECONDS", 5)) * time.Second,
+		backoff: backoff{
+			initial:    time.Duration(getEnvInt("RETRY_INITIAL_INTERVAL_MS", 200)) * time.Millisecond,
+			max:        time.Duration(getEnvInt("RETRY_MAX_INTERVAL_SECONDS", 5)) * time.Second,
+			maxRetries: getMaxRetries(),
+		},
+		breaker: newCircuitBreaker(
+			getEnvInt("BREAKER_TRIP_FAILURES", 5),
+			time.Duration(getEnvInt("BREAKER_TIMEOUT_SECONDS", 5))*time.Second,
+			getEnvInt("BREAKER_MAX_REQUESTS", 1),
+		),
 	}
-	for i := 1; i <= 20; i++ {
-		order := `{"orderId":` + strconv.Itoa(i) + "}"
-		req, err := http.NewRequest("POST", daprHost+":"+daprHttpPort+"/orders", strings.NewReader(order))
-		if err != nil {
-			log.Fatal(err.Error())
-		}
+	workers := getEnvInt("WORKERS", 5)
 
-		// Adding app id as part of the header
-		req.Header.Add("dapr-app-id", "order-processor")
+	// Submit the orders with a pool of workers; each order is numbered in the order it was read, for the logs
+	start := time.Now()
+	orders := streamOrders(*rateFlag, getEnvInt("ORDER_COUNT", 20), generateOrder)
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				c.submit(j)
+			}
+		}()
+	}
+	orderCount := 0
+	for order := range orders {
+		orderCount++
+		jobs <- job{seq: orderCount, order: order}
+	}
+	close(jobs)
+	wg.Wait()
 
-		// Invoking a service
-		response, err := client.Do(req)
-		if err != nil {
-			log.Fatal(err.Error())
+	fmt.Printf("Summary: %d orders in %v: %d passed, %d rejected, %d failed, %d retries (%d while the circuit breaker was open)\n",
+		orderCount, time.Since(start).Round(time.Millisecond), c.passed.Load(), c.rejected.Load(), c.failed.Load(),
+		c.retries.Load(), c.shortCircuits.Load())
+	if c.failed.Load() > 0 {
+		os.Exit(1)
+	}
+}
+
+// job is an order to submit, with its number in the input
+type job struct {
+	seq   int
+	order []byte
+}
+
+// generateOrder returns the i-th order sent without an input file
+func generateOrder(i int) []byte {
+	order, err := json.Marshal(Order{OrderId: i, Items: []Item{{Name: items[i%len(items)], Quantity: i}}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return order
+}
+
+// submit sends an order until it passes, is rejected, or runs out of retries
+func (c *checkout) submit(j job) {
+	// Every attempt uses the same key, so the order processor processes the order once however many attempts reach it
+	key := newIdempotencyKey()
+
+	for retry := 1; ; retry++ {
+		result, err := c.send(j.order, key)
+		var rejected *rejectedError
+		var permanent *permanentError
+		switch {
+		case err == nil:
+			c.passed.Add(1)
+			fmt.Println("Order passed:", result)
+			return
+		case errors.As(err, &rejected):
+			// The order processor rejects invalid orders with a problem+json response, which isn't a transport error
+			c.rejected.Add(1)
+			fmt.Println("Order rejected:", rejected.problem)
+			return
+		case errors.As(err, &permanent):
+			c.failed.Add(1)
+			fmt.Printf("Order failed: %d: %v\n", j.seq, err)
+			return
 		}
 
-		// Read the response
-		result, err := io.ReadAll(response.Body)
+		delay, ok := c.backoff.delay(retry)
+		if !ok {
+			c.failed.Add(1)
+			fmt.Printf("Order failed: %d after %d attempts: %v\n", j.seq, retry, err)
+			return
+		}
+		c.retries.Add(1)
+		if errors.Is(err, errCircuitOpen) {
+			c.shortCircuits.Add(1)
+		} else {
+			fmt.Printf("Retrying order %d in %v: %v\n", j.seq, delay.Round(time.Millisecond), err)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// send makes one attempt to submit an order, bounded by the request timeout
+func (c *checkout) send(order []byte, key string) (string, error) {
+	err := c.breaker.allow()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(order))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Adding app id as part of the header
+	req.Header.Add("dapr-app-id", c.appId)
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("dapr-api-token", c.token)
+	}
+	req.Header.Set("Idempotency-Key", key)
+	if c.version != "" {
+		req.Header.Set("X-Order-Version", c.version)
+	}
+
+	// Invoking a service
+	response, err := c.client.Do(req)
+	if err != nil {
+		c.breaker.record(false)
+		return "", err
+	}
+	defer response.Body.Close()
+
+	// Read the response
+	result, err := io.ReadAll(response.Body)
+	if err != nil {
+		c.breaker.record(false)
+		return "", err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+		c.breaker.record(true)
+		return strings.TrimSpace(string(result)), nil
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		c.breaker.record(false)
+		return "", fmt.Errorf("response status %s", response.Status)
+	case response.StatusCode == http.StatusConflict:
+		// Another attempt with the same key is still in progress, which isn't a failure of the order processor
+		c.breaker.record(true)
+		return "", fmt.Errorf("response status %s", response.Status)
+	}
+
+	c.breaker.record(true)
+	if response.StatusCode == http.StatusBadRequest && strings.HasPrefix(response.Header.Get("Content-Type"), "application/problem+json") {
+		var problem Problem
+		err = json.Unmarshal(result, &problem)
 		if err != nil {
-			log.Fatal(err)
+			return "", &permanentError{status: response.Status, body: strings.TrimSpace(string(result))}
 		}
-		response.Body.Close()
+		return "", &rejectedError{problem: problem}
+	}
+	return "", &permanentError{status: response.Status, body: strings.TrimSpace(string(result))}
+}
+
+// newIdempotencyKey returns a random key identifying an order submission
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(key)
+}
 
-		fmt.Println("Order passed:", string(result))
+// getEnvInt reads a positive integer from the environment, falling back to def when unset
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid value for %s: %q", name, value)
+	}
+	return n
+}
+
+// getMaxRetries reads MAX_RETRIES, which is -1 to retry forever by default, or the number of retries of an order
+func getMaxRetries() int {
+	value := os.Getenv("MAX_RETRIES")
+	if value == "" {
+		return -1
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < -1 {
+		log.Fatalf("Invalid value for MAX_RETRIES: %q", value)
 	}
+	return n
 }
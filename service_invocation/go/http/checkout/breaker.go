@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling the order processor while the circuit breaker is open
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops calling the order processor after consecutive failures, like the simpleCB policy of
+// resiliency.yaml: it opens after tripAfter consecutive failures, lets maxRequests trial requests through once
+// it has been open for timeout, and closes again when they succeed.
+type circuitBreaker struct {
+	tripAfter   int
+	timeout     time.Duration
+	maxRequests int
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+func newCircuitBreaker(tripAfter int, timeout time.Duration, maxRequests int) *circuitBreaker {
+	return &circuitBreaker{tripAfter: tripAfter, timeout: timeout, maxRequests: maxRequests}
+}
+
+// allow reports whether a request may be sent, and returns errCircuitOpen when it may not
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitOpen && time.Since(b.openedAt) >= b.timeout {
+		b.state = circuitHalfOpen
+		b.trials = 0
+	}
+	switch b.state {
+	case circuitOpen:
+		return errCircuitOpen
+	case circuitHalfOpen:
+		if b.trials >= b.maxRequests {
+			return errCircuitOpen
+		}
+		b.trials++
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a request it allowed
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		if b.state == circuitHalfOpen {
+			fmt.Println("Circuit breaker closed")
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	switch {
+	case b.state == circuitHalfOpen:
+		fmt.Println("Circuit breaker reopened after a failed trial request")
+	case b.state == circuitClosed && b.failures >= b.tripAfter:
+		fmt.Println("Circuit breaker opened after", b.failures, "consecutive failures")
+	default:
+		return
+	}
+	b.state = circuitOpen
+	b.openedAt = time.Now()
+}
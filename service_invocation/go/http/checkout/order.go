@@ -0,0 +1,38 @@
+package main
+
+import "strings"
+
+type Order struct {
+	OrderId int    `json:"orderId"`
+	Items   []Item `json:"items"`
+}
+
+type Item struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+// Problem is the problem+json body of a rejected order
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Errors []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (p Problem) String() string {
+	reasons := []string{}
+	if p.Detail != "" {
+		reasons = append(reasons, p.Detail)
+	}
+	for _, e := range p.Errors {
+		reasons = append(reasons, e.Field+" "+e.Message)
+	}
+	return p.Title + ": " + strings.Join(reasons, ", ")
+}
+
+var items = []string{"apples", "bananas", "oranges"}
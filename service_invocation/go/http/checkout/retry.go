@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff computes the delays between the attempts of a request: exponential from initial, capped at max, with
+// full jitter so that the workers don't retry in lockstep. maxRetries is the number of retries after the first
+// attempt, or -1 to retry forever.
+type backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	maxRetries int
+}
+
+// delay returns the delay before the given retry, starting at 1, and false when no retry is left
+func (b backoff) delay(retry int) (time.Duration, bool) {
+	if b.maxRetries >= 0 && retry > b.maxRetries {
+		return 0, false
+	}
+	d := b.initial
+	for i := 1; i < retry && d < b.max; i++ {
+		d *= 2
+	}
+	d = min(d, b.max)
+	return time.Duration(rand.Int63n(int64(d)) + 1), true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	inputFlag  = flag.String("input", "", "file to read the orders from, or - for stdin; orders are generated when empty")
+	formatFlag = flag.String("format", "", "format of the input: json (an array), ndjson or csv; detected when empty")
+)
+
+// maxOrderLineSize is the longest NDJSON line accepted
+const maxOrderLineSize = 1 << 20
+
+// streamOrders returns the orders to send, at most rate per second when rate is positive. The orders are read
+// from the -input file as they are sent, so large captures don't have to fit in memory; without an input,
+// count orders are generated with generate.
+func streamOrders(rate float64, count int, generate func(i int) []byte) <-chan []byte {
+	orders := make(chan []byte)
+	go func() {
+		defer close(orders)
+		var ticker *time.Ticker
+		if rate > 0 {
+			ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+		}
+		emit := func(order []byte) {
+			if ticker != nil {
+				<-ticker.C
+			}
+			orders <- order
+		}
+
+		if *inputFlag == "" {
+			for i := 1; i <= count; i++ {
+				emit(generate(i))
+			}
+			return
+		}
+		err := readOrders(*inputFlag, *formatFlag, emit)
+		if err != nil {
+			log.Fatalf("Failed to read orders from %s: %v", *inputFlag, err)
+		}
+	}()
+	return orders
+}
+
+// readOrders reads the orders of a file, or of stdin when input is "-", and calls emit with each one
+func readOrders(input, format string, emit func(order []byte)) error {
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	br := bufio.NewReader(r)
+
+	if format == "" {
+		format = detectFormat(input, br)
+	}
+	switch format {
+	case "json":
+		return readJSONArray(br, emit)
+	case "ndjson":
+		return readNDJSON(br, emit)
+	case "csv":
+		return readCSV(br, emit)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// detectFormat uses the extension of the file, or else the first character of the input: a JSON array starts
+// with [, while NDJSON starts with the first object
+func detectFormat(input string, br *bufio.Reader) string {
+	switch strings.ToLower(filepath.Ext(input)) {
+	case ".json":
+		return "json"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".csv":
+		return "csv"
+	}
+	for {
+		c, err := br.Peek(1)
+		if err != nil {
+			return "ndjson"
+		}
+		switch c[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		case '[':
+			return "json"
+		default:
+			return "ndjson"
+		}
+	}
+}
+
+func readJSONArray(r io.Reader, emit func(order []byte)) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected a JSON array of orders")
+	}
+	for dec.More() {
+		var order json.RawMessage
+		err = dec.Decode(&order)
+		if err != nil {
+			return err
+		}
+		emit(order)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func readNDJSON(r io.Reader, emit func(order []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxOrderLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		order := bytes.TrimSpace(scanner.Bytes())
+		if len(order) == 0 {
+			continue
+		}
+		if !json.Valid(order) {
+			return fmt.Errorf("line %d is not valid JSON", line)
+		}
+		emit(bytes.Clone(order))
+	}
+	return scanner.Err()
+}
+
+// readCSV turns each row into a JSON object keyed by the header row. Cells holding JSON numbers, booleans,
+// objects or arrays keep their type, and the other cells are strings.
+func readCSV(r io.Reader, emit func(order []byte)) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		order := make(map[string]any, len(header))
+		for i, name := range header {
+			order[name] = csvValue(row[i])
+		}
+		data, err := json.Marshal(order)
+		if err != nil {
+			return err
+		}
+		emit(data)
+	}
+}
+
+func csvValue(cell string) any {
+	if cell != "" && cell != "null" && cell[0] != '"' && json.Valid([]byte(cell)) {
+		return json.RawMessage(cell)
+	}
+	return cell
+}
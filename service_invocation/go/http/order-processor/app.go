@@ -1,36 +1,226 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	stateStoreComponentName = "statestore"
+
+	// maxOrderSize is the largest request body accepted for an order
+	maxOrderSize = 1 << 20
+)
+
+// Problem is an RFC 9457 problem details response
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// OrderReceipt is the v2 response, which wraps the order instead of echoing it
+type OrderReceipt struct {
+	Version       string `json:"version"`
+	Status        string `json:"status"`
+	TotalQuantity int    `json:"totalQuantity"`
+	Order         Order  `json:"order"`
+}
+
+// responseVersion selects the shape of the order responses, from ORDER_RESPONSE_VERSION: v1 echoes the order,
+// v2 responds with an OrderReceipt
+var responseVersion = "v1"
+
 func getOrder(w http.ResponseWriter, r *http.Request) {
-	data, err := io.ReadAll(r.Body)
+	data, err := readBody(w, r)
+	if err != nil {
+		return
+	}
+
+	var order Order
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&order)
 	if err != nil {
-		log.Println("Error reading body:", err.Error())
+		fmt.Println("Order rejected:", string(data))
+		writeProblem(w, http.StatusBadRequest, Problem{Type: "/problems/malformed-order", Title: "The order could not be parsed", Detail: err.Error()})
+		return
+	}
+	if errs := order.Validate(); len(errs) > 0 {
+		fmt.Println("Order rejected:", string(data))
+		writeProblem(w, http.StatusBadRequest, Problem{Type: "/problems/invalid-order", Title: "The order is invalid", Errors: errs})
+		return
 	}
+
 	fmt.Println("Order received:", string(data))
-	_, err = w.Write(data)
+	var response any = order
+	if responseVersion == "v2" {
+		receipt := OrderReceipt{Version: "v2", Status: "accepted", Order: order}
+		for _, item := range order.Items {
+			receipt.TotalQuantity += item.Quantity
+		}
+		response = receipt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Println("Error writing the response:", err.Error())
+	}
+}
+
+// readBody reads the request body up to maxOrderSize. When it fails, the request is rejected with a 413 for a
+// body that is too large, or a 400 otherwise.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOrderSize))
+	if err == nil {
+		return data, nil
+	}
+	log.Println("Error reading body:", err.Error())
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, http.StatusRequestEntityTooLarge, Problem{Type: "/problems/order-too-large", Title: "The order is too large", Detail: fmt.Sprintf("orders are limited to %d bytes", tooLarge.Limit)})
+	} else {
+		writeProblem(w, http.StatusBadRequest, Problem{Type: "/problems/unreadable-body", Title: "The request body could not be read", Detail: err.Error()})
+	}
+	return nil, err
+}
+
+// writeProblem rejects a request with a problem+json response
+func writeProblem(w http.ResponseWriter, status int, problem Problem) {
+	problem.Status = status
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	err := json.NewEncoder(w).Encode(problem)
 	if err != nil {
 		log.Println("Error writing the response:", err.Error())
 	}
 }
 
 func main() {
-	// Create a new router and respond to POST /orders requests
+	daprHost := os.Getenv("DAPR_HOST")
+	if daprHost == "" {
+		daprHost = "http://localhost"
+	}
+	daprHttpPort := os.Getenv("DAPR_HTTP_PORT")
+	if daprHttpPort == "" {
+		daprHttpPort = "3500"
+	}
+	daprURL := daprHost + ":" + daprHttpPort
+	appPort := os.Getenv("APP_PORT")
+	if appPort == "" {
+		appPort = "6006"
+	}
+	if version := os.Getenv("ORDER_RESPONSE_VERSION"); version != "" {
+		if version != "v1" && version != "v2" {
+			log.Fatalf("Invalid value for ORDER_RESPONSE_VERSION: %q", version)
+		}
+		responseVersion = version
+	}
+	client := &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &daprTokenTransport{token: os.Getenv("DAPR_API_TOKEN"), base: http.DefaultTransport},
+	}
+
+	// Responses are kept for retries of a request for IDEMPOTENCY_TTL_SECONDS, one day by default
+	idempotency := newIdempotencyStore(client, daprURL, stateStoreComponentName, getEnvInt("IDEMPOTENCY_TTL_SECONDS", 24*60*60))
+	health := newHealth(client, daprURL)
+
+	// Create a new router and respond to POST /orders requests, and to the health checks
 	r := mux.NewRouter()
-	r.HandleFunc("/orders", getOrder).Methods("POST")
+	// Only the sidecar may submit orders when APP_API_TOKEN is set, while the health checks stay open to the orchestrator
+	r.Handle("/orders", requireAppToken(idempotency.idempotent(getOrder))).Methods("POST")
+	r.HandleFunc("/healthz", health.healthz).Methods("GET")
+	r.HandleFunc("/readyz", health.readyz).Methods("GET")
+
+	// Start the server listening on APP_PORT, port 6006 by default
+	srv := &http.Server{Addr: ":" + appPort, Handler: r}
+	go func() {
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Error starting HTTP server: ", err)
+		}
+	}()
+
+	// On SIGTERM, stop taking new requests and let the requests in flight finish for up to
+	// SHUTDOWN_GRACE_PERIOD_SECONDS, 30 seconds by default
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	health.shuttingDown.Store(true)
 
-	// Start the server listening on port 6006
-	// This is a blocking call
-	err := http.ListenAndServe(":6006", r)
-	if !errors.Is(err, http.ErrServerClosed) {
-		log.Println("Error starting HTTP server")
+	// Keep serving while /readyz reports 503 for SHUTDOWN_DRAIN_SECONDS, 5 seconds by default, so the
+	// orchestrator sees the app isn't ready and stops sending it requests before the listener closes
+	drainDelay := time.Duration(getEnvInt("SHUTDOWN_DRAIN_SECONDS", 5)) * time.Second
+	fmt.Println("Not ready, draining for", drainDelay)
+	time.Sleep(drainDelay)
+
+	gracePeriod := time.Duration(getEnvInt("SHUTDOWN_GRACE_PERIOD_SECONDS", 30)) * time.Second
+	fmt.Println("Shutting down, waiting up to", gracePeriod, "for requests in flight")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("Requests still in flight after the grace period:", err.Error())
+		srv.Close()
+		return
+	}
+	fmt.Println("Shut down")
+}
+
+// requireAppToken rejects the orders without a valid dapr-api-token header with a problem response, when
+// APP_API_TOKEN is set
+func requireAppToken(next http.Handler) http.Handler {
+	token := os.Getenv("APP_API_TOKEN")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("dapr-api-token")), []byte(token)) != 1 {
+			writeProblem(w, http.StatusUnauthorized, Problem{Type: "/problems/unauthenticated", Title: "The request doesn't carry a valid dapr-api-token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// daprTokenTransport authenticates the requests to the sidecar with DAPR_API_TOKEN, when it is set
+type daprTokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *daprTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("dapr-api-token", t.token)
+	return t.base.RoundTrip(req)
+}
+
+// getEnvInt reads a positive integer from the environment, falling back to def when unset
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid value for %s: %q", name, value)
 	}
+	return n
 }
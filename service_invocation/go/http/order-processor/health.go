@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// sidecarCheckTimeout bounds the call to the sidecar health endpoint made by each readiness check
+const sidecarCheckTimeout = 2 * time.Second
+
+// health serves the liveness and readiness endpoints of the app
+type health struct {
+	client       *http.Client
+	daprURL      string
+	shuttingDown atomic.Bool
+}
+
+func newHealth(client *http.Client, daprURL string) *health {
+	return &health{client: client, daprURL: daprURL}
+}
+
+// healthz reports that the process is alive; it doesn't depend on the sidecar, so a sidecar outage doesn't
+// get the app restarted
+func (h *health) healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, "ok", "")
+}
+
+// readyz reports whether the app can take requests: it isn't shutting down, and its sidecar is reachable
+func (h *health) readyz(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		writeStatus(w, http.StatusServiceUnavailable, "shutting down", "")
+		return
+	}
+	err := h.checkSidecar(r.Context())
+	if err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, "sidecar unavailable", err.Error())
+		return
+	}
+	writeStatus(w, http.StatusOK, "ready", "")
+}
+
+// checkSidecar calls the health endpoint of the Dapr sidecar, which returns 204 when the sidecar is healthy
+func (h *health) checkSidecar(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, sidecarCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.daprURL+"/v1.0/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("sidecar health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// healthStatus is the body of the health endpoints
+type healthStatus struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func writeStatus(w http.ResponseWriter, code int, status, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(healthStatus{Status: status, Detail: detail})
+	if err != nil {
+		log.Println("Error writing the response:", err.Error())
+	}
+}
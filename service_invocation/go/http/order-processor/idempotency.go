@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecord is the response sent for an idempotency key, saved in the state store. Status is 0 while
+// the first request with the key is still being processed.
+type idempotencyRecord struct {
+	RequestHash string `json:"requestHash"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// idempotencyStore keeps the idempotency records in a Dapr state store, through the Dapr HTTP API
+type idempotencyStore struct {
+	client *http.Client
+	url    string
+	ttl    int
+}
+
+func newIdempotencyStore(client *http.Client, daprURL, storeName string, ttl int) *idempotencyStore {
+	return &idempotencyStore{client: client, url: daprURL + "/v1.0/state/" + storeName, ttl: ttl}
+}
+
+func recordKey(key string) string {
+	return "idempotency-" + key
+}
+
+// recordURL is the state API URL of the record of a key, which is escaped as it comes from a request header
+func (s *idempotencyStore) recordURL(key string) string {
+	return s.url + "/" + url.PathEscape(recordKey(key))
+}
+
+// get returns the record of a key, or nil when there is none
+func (s *idempotencyStore) get(key string) (*idempotencyRecord, error) {
+	resp, err := s.client.Get(s.recordURL(key))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get idempotency record: %s", resp.Status)
+	}
+	var record idempotencyRecord
+	err = json.NewDecoder(resp.Body).Decode(&record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// save writes the record of a key. When create is set, the write uses first-write concurrency; the sidecar
+// reports a key that is already taken as a generic save error, so callers look the record up when it fails.
+func (s *idempotencyStore) save(key string, record idempotencyRecord, create bool) error {
+	item := map[string]any{
+		"key":      recordKey(key),
+		"value":    record,
+		"metadata": map[string]string{"ttlInSeconds": strconv.Itoa(s.ttl)},
+	}
+	if create {
+		item["options"] = map[string]string{"concurrency": "first-write"}
+	}
+	body, err := json.Marshal([]any{item})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to save idempotency record: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *idempotencyStore) delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.recordURL(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to delete idempotency record: %s", resp.Status)
+	}
+	return nil
+}
+
+// responseRecorder captures the response of a handler, so it can be saved with its idempotency key
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+// idempotent processes each Idempotency-Key once: retries of a request get the response of the first request
+// without running the handler again. Requests without the header are always processed.
+func (s *idempotencyStore) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength || strings.Contains(key, "||") {
+			writeProblem(w, http.StatusBadRequest, Problem{Type: "/problems/invalid-idempotency-key", Title: "The Idempotency-Key header is invalid"})
+			return
+		}
+
+		data, err := readBody(w, r)
+		if err != nil {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), data...))
+		requestHash := hex.EncodeToString(hash[:])
+
+		// Replay the response of a key that was already used
+		record, err := s.get(key)
+		if err != nil {
+			log.Println("Error getting idempotency record:", err.Error())
+			writeProblem(w, http.StatusServiceUnavailable, Problem{Type: "/problems/state-unavailable", Title: "The request can't be processed now, retry it later"})
+			return
+		}
+		if record != nil {
+			s.replay(w, key, requestHash, record)
+			return
+		}
+
+		// Reserve the key, then process the request and save its response. When the reservation fails, a
+		// concurrent request may have reserved the key first, so its record is looked up again.
+		err = s.save(key, idempotencyRecord{RequestHash: requestHash}, true)
+		if err != nil {
+			log.Println("Error reserving idempotency key:", err.Error())
+			record, err = s.get(key)
+			if err != nil || record == nil {
+				writeProblem(w, http.StatusServiceUnavailable, Problem{Type: "/problems/state-unavailable", Title: "The request can't be processed now, retry it later"})
+				return
+			}
+			s.replay(w, key, requestHash, record)
+			return
+		}
+
+		rec := &responseRecorder{header: http.Header{}}
+		next(rec, r)
+		err = s.save(key, idempotencyRecord{
+			RequestHash: requestHash,
+			Status:      rec.status,
+			ContentType: rec.header.Get("Content-Type"),
+			Body:        rec.body.String(),
+		}, false)
+		if err != nil {
+			// Release the key, so a retry processes the request again instead of waiting for it forever
+			log.Println("Error saving idempotency record:", err.Error())
+			err = s.delete(key)
+			if err != nil {
+				log.Println("Error deleting idempotency record:", err.Error())
+			}
+		}
+
+		for name, values := range rec.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.status)
+		_, err = w.Write(rec.body.Bytes())
+		if err != nil {
+			log.Println("Error writing the response:", err.Error())
+		}
+	}
+}
+
+// replay sends the response recorded for a key again
+func (s *idempotencyStore) replay(w http.ResponseWriter, key, requestHash string, record *idempotencyRecord) {
+	switch {
+	case record.RequestHash != requestHash:
+		writeProblem(w, http.StatusUnprocessableEntity, Problem{Type: "/problems/idempotency-key-reused", Title: "The Idempotency-Key was already used for a different request"})
+	case record.Status == 0:
+		writeProblem(w, http.StatusConflict, Problem{Type: "/problems/request-in-progress", Title: "A request with this Idempotency-Key is in progress, retry it later"})
+	default:
+		fmt.Println("Order replayed:", key)
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		_, err := io.WriteString(w, record.Body)
+		if err != nil {
+			log.Println("Error writing the response:", err.Error())
+		}
+	}
+}
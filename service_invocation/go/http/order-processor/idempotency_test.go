@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStateStore behaves like the Dapr state API of a sidecar: a first-write save without an ETag of a key that
+// already exists fails with a 500 ERR_STATE_SAVE, not a 409
+type fakeStateStore struct {
+	mu     sync.Mutex
+	values map[string]json.RawMessage
+}
+
+func (f *fakeStateStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const prefix = "/v1.0/state/statestore"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == prefix:
+		var items []struct {
+			Key     string            `json:"key"`
+			Value   json.RawMessage   `json:"value"`
+			Options map[string]string `json:"options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, item := range items {
+			if _, ok := f.values[item.Key]; ok && item.Options["concurrency"] == "first-write" {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"errorCode":"ERR_STATE_SAVE","message":"failed saving state in state store statestore"}`))
+				return
+			}
+			f.values[item.Key] = item.Value
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(r.URL.Path, prefix+"/"):
+		key := strings.TrimPrefix(r.URL.Path, prefix+"/")
+		if r.Method == http.MethodDelete {
+			delete(f.values, key)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		value, ok := f.values[key]
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write(value)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestIdempotentReplaysRetries(t *testing.T) {
+	state := &fakeStateStore{values: map[string]json.RawMessage{}}
+	sidecar := httptest.NewServer(state)
+	defer sidecar.Close()
+	store := newIdempotencyStore(sidecar.Client(), sidecar.URL, stateStoreComponentName, 60)
+
+	calls := 0
+	handler := store.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		getOrder(w, r)
+	})
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	const key = "order/1?#%"
+	const body = `{"orderId":1,"items":[{"name":"apples","quantity":1}]}`
+	first := send(key, body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request: got status %d, want %d: %s", first.Code, http.StatusOK, first.Body)
+	}
+	if _, ok := state.values[recordKey(key)]; !ok {
+		t.Fatalf("no record saved under %q, got keys %v", recordKey(key), state.values)
+	}
+
+	retry := send(key, body)
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: got status %d, Idempotent-Replayed %q, want a replayed %d", retry.Code, retry.Header().Get("Idempotent-Replayed"), http.StatusOK)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retry: got body %q, want %q", retry.Body, first.Body)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+
+	reused := send(key, `{"orderId":2,"items":[{"name":"apples","quantity":1}]}`)
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key: got status %d, want %d", reused.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotentReservationRace(t *testing.T) {
+	state := &fakeStateStore{values: map[string]json.RawMessage{}}
+	sidecar := httptest.NewServer(state)
+	defer sidecar.Close()
+	store := newIdempotencyStore(sidecar.Client(), sidecar.URL, stateStoreComponentName, 60)
+
+	// Another request reserves the key between the lookup and the reservation of this one
+	const body = `{"orderId":1,"items":[{"name":"apples","quantity":1}]}`
+	handler := store.idempotent(getOrder)
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", "race")
+	rec := httptest.NewRecorder()
+	sidecar.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			state.values[recordKey("race")] = json.RawMessage(`{"requestHash":"other","status":0}`)
+		}
+		state.ServeHTTP(w, r)
+	})
+	handler(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+	}
+}
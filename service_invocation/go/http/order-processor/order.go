@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// catalog lists the items that can be ordered
+var catalog = []string{"apples", "bananas", "oranges"}
+
+type Order struct {
+	OrderId int    `json:"orderId"`
+	Items   []Item `json:"items"`
+}
+
+type Item struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+// FieldError is a validation rule that a field of an order breaks
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate returns every rule the order breaks, or nil when the order is valid
+func (o Order) Validate() []FieldError {
+	var errs []FieldError
+	if o.OrderId == 0 {
+		errs = append(errs, FieldError{Field: "orderId", Message: "is required"})
+	} else if o.OrderId < 0 {
+		errs = append(errs, FieldError{Field: "orderId", Message: "must be positive"})
+	}
+	if len(o.Items) == 0 {
+		errs = append(errs, FieldError{Field: "items", Message: "must contain at least one item"})
+	}
+	for i, item := range o.Items {
+		if !slices.Contains(catalog, item.Name) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("items[%d].name", i), Message: fmt.Sprintf("unknown item %q", item.Name)})
+		}
+		if item.Quantity <= 0 {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("items[%d].quantity", i), Message: "must be positive"})
+		}
+	}
+	return errs
+}
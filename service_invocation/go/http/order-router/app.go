@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// versionHeader selects the version of the order processor a request is routed to, and is set on the response
+// to the version that handled it
+const versionHeader = "X-Order-Version"
+
+// forwardedHeaders are the request headers passed on to the order processor
+var forwardedHeaders = []string{"Content-Type", "Accept", "Idempotency-Key"}
+
+// router forwards the requests to one of the versions of the order processor through Dapr service invocation
+type router struct {
+	client  *http.Client
+	daprURL string
+	// appIds maps each version to the app ID of the order processor running it
+	appIds map[string]string
+	// canaryPercent is the share of the requests without a version header that go to v2
+	canaryPercent int
+}
+
+// pick returns the version a request is routed to: the one in its version header, or else v2 for canaryPercent
+// of the requests. With an Idempotency-Key, the split uses a hash of the key instead of a random number, so
+// every retry of a request reaches the version that recorded its first attempt.
+func (rt *router) pick(r *http.Request) (string, error) {
+	if version := r.Header.Get(versionHeader); version != "" {
+		if _, ok := rt.appIds[version]; !ok {
+			return "", fmt.Errorf("unknown version %q", version)
+		}
+		return version, nil
+	}
+	var n int
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = int(h.Sum32() % 100)
+	} else {
+		n = rand.Intn(100)
+	}
+	if n < rt.canaryPercent {
+		return "v2", nil
+	}
+	return "v1", nil
+}
+
+func (rt *router) forward(w http.ResponseWriter, r *http.Request) {
+	version, err := rt.pick(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	appId := rt.appIds[version]
+	method := mux.Vars(r)["method"]
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, rt.daprURL+"/"+method, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, name := range forwardedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	// Adding app id as part of the header
+	req.Header.Set("dapr-app-id", appId)
+
+	// Invoking the selected version
+	resp, err := rt.client.Do(req)
+	if err != nil {
+		log.Println("Error invoking", appId+":", err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	fmt.Printf("Routed %s /%s to %s (%s): %s\n", r.Method, method, version, appId, resp.Status)
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(versionHeader, version)
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		log.Println("Error writing the response:", err.Error())
+	}
+}
+
+func main() {
+	daprHost := os.Getenv("DAPR_HOST")
+	if daprHost == "" {
+		daprHost = "http://localhost"
+	}
+	daprHttpPort := os.Getenv("DAPR_HTTP_PORT")
+	if daprHttpPort == "" {
+		daprHttpPort = "3500"
+	}
+	appPort := os.Getenv("APP_PORT")
+	if appPort == "" {
+		appPort = "6010"
+	}
+
+	rt := &router{
+		client:  &http.Client{Transport: &daprTokenTransport{token: os.Getenv("DAPR_API_TOKEN"), base: http.DefaultTransport}},
+		daprURL: daprHost + ":" + daprHttpPort,
+		appIds: map[string]string{
+			"v1": getEnv("ORDER_PROCESSOR_V1_APP_ID", "order-processor"),
+			"v2": getEnv("ORDER_PROCESSOR_V2_APP_ID", "order-processor-v2"),
+		},
+		canaryPercent: getCanaryPercent(),
+	}
+	fmt.Printf("Routing %d%% of the requests without an %s header to v2\n", rt.canaryPercent, versionHeader)
+
+	// Create a new router and forward every method to the order processor
+	r := mux.NewRouter()
+	r.Use(requireAppToken)
+	r.HandleFunc("/{method}", rt.forward)
+
+	// Start the server; this is a blocking call
+	err := http.ListenAndServe(":"+appPort, r)
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Panic(err)
+	}
+}
+
+// requireAppToken checks the dapr-api-token header of the routed requests against APP_API_TOKEN, when it is set
+func requireAppToken(next http.Handler) http.Handler {
+	token := os.Getenv("APP_API_TOKEN")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("dapr-api-token")), []byte(token)) != 1 {
+			http.Error(w, "missing or invalid dapr-api-token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// daprTokenTransport authenticates the requests to the sidecar with DAPR_API_TOKEN, when it is set
+type daprTokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *daprTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("dapr-api-token", t.token)
+	return t.base.RoundTrip(req)
+}
+
+func getEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// getCanaryPercent reads CANARY_PERCENT, the share of the requests routed to v2, from 0 to 100
+func getCanaryPercent() int {
+	value := os.Getenv("CANARY_PERCENT")
+	if value == "" {
+		return 10
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 100 {
+		log.Fatalf("Invalid value for CANARY_PERCENT: %q", value)
+	}
+	return n
+}
@@ -0,0 +1,5 @@
+module order_router_example
+
+go 1.21
+
+require github.com/gorilla/mux v1.8.0
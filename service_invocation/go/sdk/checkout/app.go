@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+var (
+	appIdFlag  = flag.String("app-id", "order-processor", "app ID of the service to invoke")
+	methodFlag = flag.String("method", "orders", "method of the service to invoke")
+	rateFlag   = flag.Float64("rate", 0, "largest number of orders sent per second; unlimited when 0")
+)
+
+func main() {
+	flag.Parse()
+
+	// Create a new client for Dapr using the SDK
+	client, err := dapr.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	orders := streamOrders(*rateFlag, 20, func(i int) []byte {
+		return []byte(`{"orderId":` + strconv.Itoa(i) + "}")
+	})
+	for order := range orders {
+		content := &dapr.DataContent{
+			ContentType: "application/json",
+			Data:        order,
+		}
+
+		// Invoke the method of the service
+		result, err := client.InvokeMethodWithContent(context.Background(), *appIdFlag, *methodFlag, "post", content)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Order passed:", string(result))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dapr/go-sdk/service/common"
+	daprd "github.com/dapr/go-sdk/service/http"
+)
+
+func getOrder(ctx context.Context, in *common.InvocationEvent) (*common.Content, error) {
+	fmt.Println("Order received:", string(in.Data))
+	return &common.Content{
+		Data:        in.Data,
+		ContentType: in.ContentType,
+		DataTypeURL: in.DataTypeURL,
+	}, nil
+}
+
+func main() {
+	// Create a new service listening on port 6006 and respond to invocations of the orders method
+	s := daprd.NewService(":6006")
+	err := s.AddServiceInvocationHandler("/orders", getOrder)
+	if err != nil {
+		log.Fatalf("error adding invocation handler: %v", err)
+	}
+
+	// Start the service
+	// This is a blocking call
+	err = s.Start()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("error listening: %v", err)
+	}
+}
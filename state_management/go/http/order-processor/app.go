@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -23,53 +22,178 @@ func main() {
 	if daprHttpPort == "" {
 		daprHttpPort = "3500"
 	}
+	daprURL := daprHost + ":" + daprHttpPort
 
-	client := http.Client{
-		Timeout: 15 * time.Second,
+	// Keep enough idle connections to the sidecar for the concurrent modes
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 100
+	client := &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &daprTokenTransport{token: os.Getenv("DAPR_API_TOKEN"), base: transport},
 	}
 
-	for i := 1; i <= 100; i++ {
-		orderId := i
-		order := `{"orderId":` + strconv.Itoa(orderId) + "}"
-		state, _ := json.Marshal([]map[string]string{
-			{
-				"key":   strconv.Itoa(orderId),
-				"value": order,
-			},
-		})
+	// Values are compressed when COMPRESSION is set, then encrypted with the crypto component when ENCRYPTION_KEY
+	// is set, and finally split into chunks when they are larger than CHUNK_SIZE bytes
+	var storeOpts []StoreOption
+	if codec := newCompressionCodec(os.Getenv("COMPRESSION")); codec != nil {
+		storeOpts = append(storeOpts, WithCodec(codec))
+	}
+	if os.Getenv("ENCRYPTION_KEY") != "" && os.Getenv("APP_MODE") == "query" {
+		log.Fatal("Query mode can't be used with ENCRYPTION_KEY, since the state store can't search encrypted values")
+	}
+	if codec := newCryptoCodec(client, daprURL, os.Getenv("ENCRYPTION_KEY")); codec != nil {
+		storeOpts = append(storeOpts, WithCodec(codec))
+	}
+	chunkSize := 0
+	if os.Getenv("CHUNK_SIZE") != "" {
+		chunkSize = getEnvInt("CHUNK_SIZE", 0)
+		storeOpts = append(storeOpts, WithChunkSize(chunkSize))
+	}
+
+	// Every change to an order is recorded in its history when AUDIT_HISTORY is true
+	rawOrders := newHTTPStore[json.RawMessage](client, daprURL, stateStoreComponentName, storeOpts...)
+	history := &auditLog{
+		entries: newHTTPStore[AuditEntry](client, daprURL, stateStoreComponentName, storeOpts...),
+		heads:   newHTTPStore[int](client, daprURL, stateStoreComponentName, storeOpts...),
+	}
+	audit := os.Getenv("AUDIT_HISTORY") == "true"
+	if audit {
+		rawOrders = newAuditedStore(rawOrders, history)
+	}
+
+	// Orders are stored as versioned documents, which are upgraded to the current schema version when they are
+	// read, and written back when MIGRATION_WRITE_BACK is true
+	orders := newMigratingStore[Order](rawOrders, orderMigrations, os.Getenv("MIGRATION_WRITE_BACK") == "true")
+	orderCount := getEnvInt("ORDER_COUNT", 100)
+
+	// Orders are cached in memory when CACHE_SIZE is set, except in TTL mode, which polls the state store to see
+	// the orders expire, and in outbox mode, which uses another state store
+	mode := os.Getenv("APP_MODE")
+	var cache *cachedStore[Order]
+	if os.Getenv("CACHE_SIZE") != "" && mode != "ttl" && mode != "outbox" {
+		cache = newCachedStore(orders, getEnvInt("CACHE_SIZE", 0), newPublisher(client, daprURL, cachePubSubName))
+		orders = cache
+		if mode != "serve" {
+			// The order service receives the invalidations itself
+			cache.listen(os.Getenv("APP_PORT"))
+		}
+	}
+
+	if mode == "outbox" {
+		// Events are published with the value as saved, so values aren't compressed, encrypted or split
+		orders = newHTTPStore[Order](client, daprURL, outboxStoreComponentName)
+	}
+	// Disable the mode when the state store doesn't support the features it relies on
+	var features []string
+	if audit && mode != "outbox" {
+		// History entries are written in the same transaction as the change
+		features = append(features, FeatureTransactional)
+	}
+	if chunkSize > 0 && mode != "outbox" {
+		// A value split into chunks is saved in one transaction with its manifest
+		features = append(features, FeatureTransactional)
+	}
+	if !checkFeatures(context.Background(), orders, mode, features...) {
+		return
+	}
+
+	switch mode {
+	case "", "single":
+		runSingle(orders, orderCount)
+	case "bulk":
+		runBulk(orders, orderCount, getEnvInt("BATCH_SIZE", 10))
+	case "etag":
+		runETag(orders, parseConcurrency(os.Getenv("CONCURRENCY")), getEnvInt("WRITERS", 5), getEnvInt("UPDATES", 10))
+	case "transaction":
+		runTransaction(orders, newHTTPStore[customerOrders](client, daprURL, stateStoreComponentName, storeOpts...), orderCount)
+	case "query":
+		runQuery(orders, orderCount, buildQuery())
+	case "ttl":
+		runTTL(orders, orderCount, ttlPolicy(), time.Duration(getEnvInt("POLL_INTERVAL_SECONDS", 1))*time.Second)
+	case "outbox":
+		runOutbox(orders, orderCount)
+	case "large":
+		runLarge(orders, orderCount, getEnvInt("ATTACHMENT_SIZE", 1<<20))
+	case "migrate":
+		runMigrate(rawOrders, orderMigrations, getEnvInt("PAGE_SIZE", 100))
+	case "history":
+		orderId := getEnvInt("ORDER_ID", 0)
+		if orderId == 0 {
+			log.Fatal("ORDER_ID is required in history mode")
+		}
+		runHistory(history, strconv.Itoa(orderId))
+	case "bench":
+		operations, duration := benchLimits()
+		runBench(orders, getEnvInt("WORKERS", 10), operations, duration)
+	case "serve":
+		appPort := os.Getenv("APP_PORT")
+		if appPort == "" {
+			appPort = "6008"
+		}
+		runServer(orders, newHTTPStore[[]int](client, daprURL, stateStoreComponentName, storeOpts...), appPort)
+	default:
+		log.Fatalf("Unknown APP_MODE %q", mode)
+	}
+
+	if cache != nil {
+		fmt.Println("Cache:", cache.Stats())
+	}
+}
+
+// runSingle saves, retrieves and deletes each order with one request per key
+func runSingle(orders Store[Order], orderCount int) {
+	for i := 1; i <= orderCount; i++ {
+		order := Order{OrderId: i}
 
 		// Save state into a state store
-		res, err := client.Post(daprHost+":"+daprHttpPort+"/v1.0/state/"+stateStoreComponentName, "application/json", bytes.NewReader(state))
+		err := orders.Put(context.Background(), order.Key(), order)
 		if err != nil {
 			panic(err)
 		}
-		res.Body.Close()
 		fmt.Println("Saved Order:", order)
 
 		// Get state from a state store
-		getResponse, err := client.Get(daprHost + ":" + daprHttpPort + "/v1.0/state/" + stateStoreComponentName + "/" + strconv.Itoa(orderId))
-		if err != nil {
-			panic(err)
-		}
-		result, err := io.ReadAll(getResponse.Body)
+		result, err := orders.Get(context.Background(), order.Key())
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println("Retrieved Order:", string(result))
-		getResponse.Body.Close()
+		fmt.Println("Retrieved Order:", result.Value)
 
 		// Delete state from the state store
-		req, err := http.NewRequest(http.MethodDelete, daprHost+":"+daprHttpPort+"/v1.0/state/"+stateStoreComponentName+"/"+strconv.Itoa(orderId), nil)
-		if err != nil {
-			panic(err)
-		}
-		res, err = client.Do(req)
+		err = orders.Delete(context.Background(), order.Key())
 		if err != nil {
 			panic(err)
 		}
-		res.Body.Close()
 		log.Println("Deleted Order:", order)
 
-		time.Sleep(5000)
+		time.Sleep(time.Second)
+	}
+}
+
+// daprTokenTransport authenticates the requests to the sidecar with DAPR_API_TOKEN, when it is set
+type daprTokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *daprTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("dapr-api-token", t.token)
+	return t.base.RoundTrip(req)
+}
+
+// getEnvInt reads a positive integer from the environment, falling back to def when unset
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid value for %s: %q", name, value)
 	}
+	return n
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// runBulk saves, retrieves and deletes the orders in batches of batchSize keys,
+// using a single call to the state store per operation and batch
+func runBulk(orders Store[Order], orderCount, batchSize int) {
+	ctx := context.Background()
+
+	for first := 1; first <= orderCount; first += batchSize {
+		last := min(first+batchSize-1, orderCount)
+
+		keys := make([]string, 0, last-first+1)
+		entries := make([]*Entry[Order], 0, last-first+1)
+		for orderId := first; orderId <= last; orderId++ {
+			order := Order{OrderId: orderId}
+			keys = append(keys, order.Key())
+			entries = append(entries, &Entry[Order]{Key: order.Key(), Value: order})
+		}
+
+		// Save all the orders in the batch into the state store
+		err := orders.PutAll(ctx, entries)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved Orders: %d-%d\n", first, last)
+
+		// Get all the orders in the batch from the state store
+		// Failures are reported per key, so one bad key doesn't fail the whole batch
+		results, err := orders.List(ctx, keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		failed := 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+				fmt.Printf("Failed to retrieve Order %s: %v\n", result.Key, result.Err)
+				continue
+			}
+			fmt.Println("Retrieved Order:", result.Value)
+		}
+		if failed > 0 {
+			fmt.Printf("Failed to retrieve %d of %d Orders\n", failed, len(keys))
+		}
+
+		// Delete all the orders in the batch from the state store
+		err = orders.DeleteAll(ctx, keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Deleted Orders: %d-%d\n", first, last)
+	}
+}
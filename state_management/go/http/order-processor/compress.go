@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content types of the compressed values, which mark each value with the algorithm used to compress it
+const (
+	gzipContentType = "application/gzip"
+	zstdContentType = "application/zstd"
+)
+
+// The zstd encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressionCodec compresses values before they are saved. Compressed values start with a content type
+// marker, so values compressed with any algorithm, and values saved without compression, can all be read.
+type compressionCodec struct {
+	contentType string
+}
+
+// newCompressionCodec returns the codec for the COMPRESSION setting: "gzip" or "zstd",
+// or nil when values should be stored uncompressed
+func newCompressionCodec(algorithm string) Codec {
+	switch algorithm {
+	case "":
+		return nil
+	case "gzip":
+		fmt.Println("Compressing state values with gzip")
+		return &compressionCodec{contentType: gzipContentType}
+	case "zstd":
+		fmt.Println("Compressing state values with zstd")
+		return &compressionCodec{contentType: zstdContentType}
+	default:
+		log.Fatalf("Invalid value for COMPRESSION: %q", algorithm)
+		return nil
+	}
+}
+
+func contentTypeMarker(contentType string) []byte {
+	return []byte("content-type:" + contentType + "\n")
+}
+
+// Encode compresses the value, unless compression doesn't make it smaller, as is the case for small values
+func (c *compressionCodec) Encode(ctx context.Context, data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	compressed.Write(contentTypeMarker(c.contentType))
+	switch c.contentType {
+	case gzipContentType:
+		w := gzip.NewWriter(&compressed)
+		_, err := w.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+	case zstdContentType:
+		compressed.Write(zstdEncoder.EncodeAll(data, nil))
+	}
+	if compressed.Len() >= len(data) {
+		return data, nil
+	}
+	return compressed.Bytes(), nil
+}
+
+// Decode decompresses the value according to its marker, and returns values without a marker unchanged
+func (c *compressionCodec) Decode(ctx context.Context, data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, contentTypeMarker(gzipContentType)):
+		r, err := gzip.NewReader(bytes.NewReader(data[len(contentTypeMarker(gzipContentType)):]))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	case bytes.HasPrefix(data, contentTypeMarker(zstdContentType)):
+		return zstdDecoder.DecodeAll(data[len(contentTypeMarker(zstdContentType)):], nil)
+	default:
+		return data, nil
+	}
+}
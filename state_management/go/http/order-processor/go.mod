@@ -1,3 +1,8 @@
 module order_processor_example
 
 go 1.21
+
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/klauspost/compress v1.17.4
+)
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// runLarge saves orders with an attachment of attachmentSize bytes, then checks that each order reads back
+// intact. Run it with COMPRESSION and CHUNK_SIZE to store values larger than the state store accepts.
+func runLarge(orders Store[Order], orderCount, attachmentSize int) {
+	ctx := context.Background()
+
+	for orderId := 1; orderId <= orderCount; orderId++ {
+		attachment := Attachment{
+			Name:        "invoice-" + strconv.Itoa(orderId) + ".txt",
+			ContentType: "text/plain",
+			Size:        attachmentSize,
+			Data:        invoice(orderId, attachmentSize),
+		}
+		order := Order{OrderId: orderId, Attachments: []Attachment{attachment}}
+
+		err := orders.Put(ctx, order.Key(), order)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved Order %d with a %d byte attachment\n", orderId, len(attachment.Data))
+
+		result, err := orders.Get(ctx, order.Key())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(result.Value.Attachments) != 1 || !bytes.Equal(result.Value.Attachments[0].Data, attachment.Data) {
+			log.Fatalf("Order %d was corrupted", orderId)
+		}
+		fmt.Printf("Retrieved Order %d with a %d byte attachment\n", orderId, len(result.Value.Attachments[0].Data))
+
+		err = orders.Delete(ctx, order.Key())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Deleted Order %d\n", orderId)
+	}
+}
+
+// invoice returns a text document of size bytes, which compresses well like most real attachments
+func invoice(orderId, size int) []byte {
+	var b bytes.Buffer
+	for line := 1; b.Len() < size; line++ {
+		fmt.Fprintf(&b, "Order %d, line %d: 1 x widget at 9.99\n", orderId, line)
+	}
+	return b.Bytes()[:size]
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// outboxStoreComponentName is the state store component configured with the outbox pattern
+const outboxStoreComponentName = "outboxstore"
+
+// runOutbox saves each order in a state transaction on the outbox store. The sidecar publishes an
+// "order saved" event for every upsert in the transaction only if the transaction commits, so the
+// state store and the subscribers can never disagree about which orders were saved.
+func runOutbox(orders Store[Order], orderCount int) {
+	ctx := context.Background()
+
+	keys := make([]string, 0, orderCount)
+	for orderId := 1; orderId <= orderCount; orderId++ {
+		order := Order{OrderId: orderId, Customer: customerFor(orderId)}
+
+		// Only the order is upserted in the transaction, so the order is the only event published
+		op, err := orders.UpsertOp(ctx, order.Key(), order)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = orders.Transact(ctx, op)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = append(keys, order.Key())
+		fmt.Println("Saved Order with outbox event:", order)
+	}
+
+	// Deletes aren't published, so the orders can be cleaned up without sending events
+	err := orders.DeleteAll(ctx, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Deleted %d Orders\n", len(keys))
+}
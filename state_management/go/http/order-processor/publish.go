@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// newPublisher returns a function that publishes JSON events to the topics of a pub/sub component
+func newPublisher(client *http.Client, daprURL, pubsubName string) func(ctx context.Context, topic string, data any) error {
+	return func(ctx context.Context, topic string, data any) error {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, daprURL+"/v1.0/publish/"+pubsubName+"/"+topic, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(res.Body)
+			return fmt.Errorf("publish returned %s: %s", res.Status, bytes.TrimSpace(msg))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Migration upgrades a document from the previous schema version, in place
+type Migration func(doc map[string]any) error
+
+// Migrations is the registry of the migrations of a document type: the migration at index i upgrades
+// documents from version i+1 to version i+2. Documents without a version field are version 1.
+type Migrations []Migration
+
+// Current returns the schema version of the documents the app writes
+func (m Migrations) Current() int {
+	return len(m) + 1
+}
+
+// Upgrade runs the migrations a document needs to reach the current version, and reports whether it ran any
+func (m Migrations) Upgrade(data []byte) ([]byte, int, error) {
+	var doc map[string]any
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	version := 1
+	if v, ok := doc["version"].(float64); ok {
+		version = int(v)
+	}
+	if version > m.Current() {
+		return nil, 0, fmt.Errorf("document version %d is newer than version %d of this app", version, m.Current())
+	}
+	if version == m.Current() {
+		return data, version, nil
+	}
+	for v := version; v < m.Current(); v++ {
+		err = m[v-1](doc)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to migrate from version %d to %d: %w", v, v+1, err)
+		}
+	}
+	doc["version"] = m.Current()
+	data, err = json.Marshal(doc)
+	return data, version, err
+}
+
+// Stamp sets the current version on a document about to be written
+func (m Migrations) Stamp(data []byte) ([]byte, error) {
+	var doc map[string]any
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	doc["version"] = m.Current()
+	return json.Marshal(doc)
+}
+
+// migratingStore stores values of type T as versioned JSON documents on top of a raw store. Documents saved
+// with an older schema version are upgraded when they are read, and written back when writeBack is set.
+type migratingStore[T any] struct {
+	raw        Store[json.RawMessage]
+	migrations Migrations
+	writeBack  bool
+}
+
+func newMigratingStore[T any](raw Store[json.RawMessage], migrations Migrations, writeBack bool) Store[T] {
+	return &migratingStore[T]{raw: raw, migrations: migrations, writeBack: writeBack}
+}
+
+func (s *migratingStore[T]) Get(ctx context.Context, key string) (*Entry[T], error) {
+	raw, err := s.raw.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	entries := s.toEntries(ctx, []*Entry[json.RawMessage]{raw})
+	return entries[0], entries[0].Err
+}
+
+func (s *migratingStore[T]) Put(ctx context.Context, key string, value T, opts ...WriteOption) error {
+	data, err := s.encode(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	return s.raw.Put(ctx, key, data, opts...)
+}
+
+func (s *migratingStore[T]) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	return s.raw.Delete(ctx, key, opts...)
+}
+
+func (s *migratingStore[T]) List(ctx context.Context, keys []string) ([]*Entry[T], error) {
+	raws, err := s.raw.List(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.toEntries(ctx, raws), nil
+}
+
+func (s *migratingStore[T]) PutAll(ctx context.Context, entries []*Entry[T]) error {
+	raws := make([]*Entry[json.RawMessage], 0, len(entries))
+	for _, entry := range entries {
+		data, err := s.encode(entry.Value)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", entry.Key, err)
+		}
+		raws = append(raws, &Entry[json.RawMessage]{Key: entry.Key, Value: data, ETag: entry.ETag, Metadata: entry.Metadata})
+	}
+	return s.raw.PutAll(ctx, raws)
+}
+
+func (s *migratingStore[T]) DeleteAll(ctx context.Context, keys []string) error {
+	return s.raw.DeleteAll(ctx, keys)
+}
+
+func (s *migratingStore[T]) Query(ctx context.Context, query stateQuery, metadata map[string]string) ([]*Entry[T], string, error) {
+	raws, token, err := s.raw.Query(ctx, query, metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.toEntries(ctx, raws), token, nil
+}
+
+func (s *migratingStore[T]) UpsertOp(ctx context.Context, key string, value T, opts ...WriteOption) (Op, error) {
+	data, err := s.encode(value)
+	if err != nil {
+		return Op{}, fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	return s.raw.UpsertOp(ctx, key, data, opts...)
+}
+
+func (s *migratingStore[T]) DeleteOp(ctx context.Context, key string, opts ...WriteOption) (Op, error) {
+	return s.raw.DeleteOp(ctx, key, opts...)
+}
+
+func (s *migratingStore[T]) Transact(ctx context.Context, ops ...Op) error {
+	return s.raw.Transact(ctx, ops...)
+}
+
+func (s *migratingStore[T]) Component(ctx context.Context) (*ComponentInfo, error) {
+	return s.raw.Component(ctx)
+}
+
+func (s *migratingStore[T]) encode(value T) (json.RawMessage, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return s.migrations.Stamp(data)
+}
+
+// toEntries upgrades and decodes the raw entries, then writes the upgraded documents back when writeBack is set
+func (s *migratingStore[T]) toEntries(ctx context.Context, raws []*Entry[json.RawMessage]) []*Entry[T] {
+	entries := make([]*Entry[T], 0, len(raws))
+	var upgraded []*Entry[json.RawMessage]
+	for _, raw := range raws {
+		entry := &Entry[T]{Key: raw.Key, ETag: raw.ETag, Metadata: raw.Metadata, Err: raw.Err}
+		entries = append(entries, entry)
+		if raw.Err != nil {
+			continue
+		}
+		data, version, err := s.migrations.Upgrade(raw.Value)
+		if err != nil {
+			entry.Err = fmt.Errorf("failed to upgrade %s: %w", raw.Key, err)
+			continue
+		}
+		entry.Err = json.Unmarshal(data, &entry.Value)
+		if entry.Err == nil && version < s.migrations.Current() && s.writeBack {
+			upgraded = append(upgraded, &Entry[json.RawMessage]{Key: raw.Key, Value: data, ETag: raw.ETag})
+		}
+	}
+	if len(upgraded) > 0 {
+		s.writeUpgraded(ctx, upgraded, entries)
+	}
+	return entries
+}
+
+// writeUpgraded saves the upgraded documents, unless they changed since they were read, and updates the ETags
+// of the entries so they can still be used for conditional writes
+func (s *migratingStore[T]) writeUpgraded(ctx context.Context, upgraded []*Entry[json.RawMessage], entries []*Entry[T]) {
+	keys := make([]string, 0, len(upgraded))
+	for _, raw := range upgraded {
+		// Only overwrite the document that was upgraded, never a newer one
+		err := s.raw.Put(ctx, raw.Key, raw.Value, WithETag(raw.ETag))
+		if err != nil && !errors.Is(err, ErrETagMismatch) {
+			log.Printf("Failed to write back the upgraded document %s: %v", raw.Key, err)
+		}
+		if err == nil {
+			fmt.Printf("Upgraded %s to version %d\n", raw.Key, s.migrations.Current())
+			keys = append(keys, raw.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	results, err := s.raw.List(ctx, keys)
+	if err != nil {
+		log.Println("Failed to read the ETags of the upgraded documents:", err.Error())
+		return
+	}
+	etags := make(map[string]string, len(results))
+	for _, result := range results {
+		etags[result.Key] = result.ETag
+	}
+	for _, entry := range entries {
+		if etag, ok := etags[entry.Key]; ok {
+			entry.ETag = etag
+		}
+	}
+}
+
+// runMigrate walks every document in the state store with the query API and upgrades the orders saved with an
+// older schema version, so the lazy migrations can eventually be removed
+func runMigrate(raw Store[json.RawMessage], migrations Migrations, pageSize int) {
+	ctx := context.Background()
+
+	scanned, migrated := 0, 0
+	query := stateQuery{Page: queryPage{Limit: pageSize}}
+	for {
+		results, token, err := raw.Query(ctx, query, queryMetadata())
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, result := range results {
+			scanned++
+			if result.Err != nil {
+				fmt.Printf("Failed to read %s: %v\n", result.Key, result.Err)
+				continue
+			}
+			var doc map[string]any
+			if json.Unmarshal(result.Value, &doc) != nil || doc["orderId"] == nil {
+				// Skip the documents that aren't orders, such as indexes
+				continue
+			}
+			data, version, err := migrations.Upgrade(result.Value)
+			if err != nil {
+				fmt.Printf("Failed to upgrade Order %s: %v\n", result.Key, err)
+				continue
+			}
+			if version == migrations.Current() {
+				continue
+			}
+			err = raw.Put(ctx, result.Key, data, WithETag(result.ETag))
+			if errors.Is(err, ErrETagMismatch) {
+				// The order changed since it was read, so leave it to be upgraded when it is next read
+				fmt.Printf("Skipped Order %s, which changed while it was migrated\n", result.Key)
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			migrated++
+			fmt.Printf("Migrated Order %s from version %d to %d\n", result.Key, version, migrations.Current())
+		}
+		if token == "" || len(results) == 0 {
+			break
+		}
+		query.Page.Token = token
+	}
+	fmt.Printf("Migrated %d of %d documents to version %d\n", migrated, scanned, migrations.Current())
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// orderIndexKey holds the IDs of all the orders created through the service, so they can be listed
+const orderIndexKey = "order-index"
+
+// orderService exposes the orders in the state store as a REST resource
+type orderService struct {
+	orders Store[Order]
+	index  Store[[]int]
+}
+
+// runServer starts a long-lived HTTP service backed by the state store, which other apps can call with Dapr service invocation
+func runServer(orders Store[Order], index Store[[]int], appPort string) {
+	s := &orderService{orders: orders, index: index}
+
+	r := mux.NewRouter()
+	r.Use(appTokenMiddleware(os.Getenv("APP_API_TOKEN")), actorMiddleware)
+	r.HandleFunc("/orders", s.listOrders).Methods("GET")
+	r.HandleFunc("/orders/{id:[0-9]+}", s.createOrder).Methods("POST")
+	r.HandleFunc("/orders/{id:[0-9]+}", s.getOrder).Methods("GET")
+	r.HandleFunc("/orders/{id:[0-9]+}", s.updateOrder).Methods("PUT")
+	r.HandleFunc("/orders/{id:[0-9]+}", s.deleteOrder).Methods("DELETE")
+
+	// With a cache, subscribe to the invalidations published by the other replicas
+	if cache, ok := orders.(*cachedStore[Order]); ok {
+		cache.routes(r)
+	}
+
+	// Start the server; this is a blocking call
+	fmt.Println("Order service listening on port", appPort)
+	err := http.ListenAndServe(":"+appPort, r)
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Panic(err)
+	}
+}
+
+func (s *orderService) listOrders(w http.ResponseWriter, r *http.Request) {
+	ids, _, err := s.getIndex(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	orders := make([]Order, 0, len(ids))
+	if len(ids) > 0 {
+		keys := make([]string, 0, len(ids))
+		for _, id := range ids {
+			keys = append(keys, strconv.Itoa(id))
+		}
+		results, err := s.orders.List(r.Context(), keys)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				log.Printf("Failed to retrieve Order %s: %v", result.Key, result.Err)
+				continue
+			}
+			orders = append(orders, result.Value)
+		}
+	}
+	writeJSON(w, http.StatusOK, "", orders)
+}
+
+func (s *orderService) createOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := readOrder(w, r)
+	if !ok {
+		return
+	}
+	if order.Status == "" {
+		order.Status = "created"
+	}
+	_, err := s.orders.Get(r.Context(), order.Key())
+	if err == nil {
+		http.Error(w, "order "+order.Key()+" already exists", http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, ErrNotFound) {
+		writeError(w, err)
+		return
+	}
+
+	// Save the order and add it to the index in one transaction, so the index never points to a missing order
+	ids, etag, err := s.getIndex(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	orderOp, err := s.orders.UpsertOp(r.Context(), order.Key(), order)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	indexOp, err := s.index.UpsertOp(r.Context(), orderIndexKey, append(ids, order.OrderId), WithETag(etag))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = s.orders.Transact(r.Context(), orderOp, indexOp)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fmt.Println("Created Order:", order)
+	writeJSON(w, http.StatusCreated, "", order)
+}
+
+func (s *orderService) getOrder(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.orders.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry.ETag, entry.Value)
+}
+
+// updateOrder replaces an existing order; when the request has an If-Match header, the update only succeeds
+// if the order still has that ETag
+func (s *orderService) updateOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := readOrder(w, r)
+	if !ok {
+		return
+	}
+	entry, err := s.orders.Get(r.Context(), order.Key())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	etag, ok := matchETag(w, r, entry.ETag)
+	if !ok {
+		return
+	}
+	err = s.orders.Put(r.Context(), order.Key(), order, WithETag(etag))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fmt.Println("Updated Order:", order)
+	writeJSON(w, http.StatusOK, "", order)
+}
+
+func (s *orderService) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["id"]
+	entry, err := s.orders.Get(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	etag, ok := matchETag(w, r, entry.ETag)
+	if !ok {
+		return
+	}
+
+	// Delete the order and remove it from the index in one transaction
+	ids, indexETag, err := s.getIndex(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ids = slices.DeleteFunc(ids, func(id int) bool { return id == entry.Value.OrderId })
+	indexOp, err := s.index.UpsertOp(r.Context(), orderIndexKey, ids, WithETag(indexETag))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	orderOp, err := s.orders.DeleteOp(r.Context(), key, WithETag(etag))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = s.orders.Transact(r.Context(), orderOp, indexOp)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fmt.Println("Deleted Order:", entry.Value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// matchETag returns the ETag to make a write conditional on: the If-Match header of the request, or else the
+// ETag the order was read with. A stale If-Match is rejected here, as a transaction would only report it as a
+// generic failure.
+func matchETag(w http.ResponseWriter, r *http.Request, current string) (string, bool) {
+	etag := r.Header.Get("If-Match")
+	if etag == "" {
+		return current, true
+	}
+	if etag != current {
+		http.Error(w, "the order changed since ETag "+etag+" was read", http.StatusPreconditionFailed)
+		return "", false
+	}
+	return etag, true
+}
+
+// getIndex returns the IDs of all the orders with the ETag of the index, which is empty when no order was created yet
+func (s *orderService) getIndex(ctx context.Context) ([]int, string, error) {
+	entry, err := s.index.Get(ctx, orderIndexKey)
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return entry.Value, entry.ETag, nil
+}
+
+// readOrder decodes the order in the request body, taking its ID from the URL
+func readOrder(w http.ResponseWriter, r *http.Request) (Order, bool) {
+	var order Order
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err := json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		http.Error(w, "invalid order: "+err.Error(), http.StatusBadRequest)
+		return order, false
+	}
+	if order.OrderId != 0 && order.OrderId != id {
+		http.Error(w, "orderId doesn't match the URL", http.StatusBadRequest)
+		return order, false
+	}
+	order.OrderId = id
+	return order, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, etag string, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("Error writing the response:", err.Error())
+	}
+}
+
+// appTokenMiddleware rejects the requests without a valid dapr-api-token header when a token is set
+func appTokenMiddleware(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("dapr-api-token")), []byte(token)) != 1 {
+				http.Error(w, "missing or invalid dapr-api-token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeError maps the store errors to HTTP status codes
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrETagMismatch):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, ErrTransactionFailed):
+		// The order or the index may have changed concurrently, which the sidecar doesn't tell apart from
+		// other failures, so the request can be retried
+		log.Println("Error running the state transaction:", err.Error())
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		log.Println("Error accessing the state store:", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+var (
+	// ErrNotFound is returned when a key doesn't exist in the state store
+	ErrNotFound = errors.New("key not found")
+	// ErrETagMismatch is returned when a write is rejected because the key changed since its ETag was read
+	ErrETagMismatch = errors.New("etag mismatch")
+	// ErrTransactionFailed is returned when a state transaction fails, including when one of its ETags is stale
+	ErrTransactionFailed = errors.New("transaction failed")
+)
+
+// Entry is a typed value stored under a key, together with its ETag and metadata
+type Entry[T any] struct {
+	Key      string
+	Value    T
+	ETag     string
+	Metadata map[string]string
+	// Err is set by List for the keys that could not be read
+	Err error
+}
+
+// Concurrency is the concurrency mode used when writing with an ETag
+type Concurrency string
+
+const (
+	FirstWrite Concurrency = "first-write"
+	LastWrite  Concurrency = "last-write"
+)
+
+type writeOptions struct {
+	etag        string
+	metadata    map[string]string
+	concurrency Concurrency
+}
+
+// WriteOption configures a single write or delete
+type WriteOption func(*writeOptions)
+
+// WithETag makes the write conditional on the key still having the given ETag
+func WithETag(etag string) WriteOption {
+	return func(o *writeOptions) { o.etag = etag }
+}
+
+// WithMetadata passes metadata, such as ttlInSeconds, to the state store
+func WithMetadata(metadata map[string]string) WriteOption {
+	return func(o *writeOptions) { o.metadata = metadata }
+}
+
+// WithConcurrency sets the concurrency mode; writes with an ETag default to first-write
+func WithConcurrency(concurrency Concurrency) WriteOption {
+	return func(o *writeOptions) { o.concurrency = concurrency }
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency == "" && o.etag != "" {
+		o.concurrency = FirstWrite
+	}
+	return o
+}
+
+// Op is a single operation of a state transaction, created with UpsertOp or DeleteOp
+type Op struct {
+	key    string
+	value  json.RawMessage
+	delete bool
+	opts   writeOptions
+	// related holds the operations that must run in the same transaction, such as the writes of the chunks
+	// of a value split by the store
+	related []Op
+}
+
+// expand returns the related operations followed by the operation itself, so a reader never finds a
+// manifest pointing to chunks that weren't written yet
+func (op Op) expand() []Op {
+	return append(op.related[:len(op.related):len(op.related)], op)
+}
+
+// Codec transforms the JSON encoding of values on their way to and from the state store, for example to encrypt them
+type Codec interface {
+	Encode(ctx context.Context, data []byte) ([]byte, error)
+	Decode(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// Store is a typed repository over a Dapr state store, which stores values of type T as JSON documents
+type Store[T any] interface {
+	// Get returns the value of a key, or ErrNotFound
+	Get(ctx context.Context, key string) (*Entry[T], error)
+	// Put saves the value of a key
+	Put(ctx context.Context, key string, value T, opts ...WriteOption) error
+	// Delete deletes a key
+	Delete(ctx context.Context, key string, opts ...WriteOption) error
+	// List returns the values of the keys in a single call, reporting failures per key in Entry.Err
+	List(ctx context.Context, keys []string) ([]*Entry[T], error)
+	// PutAll saves the entries, with their ETags and metadata, in a single call; values split into chunks are
+	// saved in a transaction each, with their manifest
+	PutAll(ctx context.Context, entries []*Entry[T]) error
+	// DeleteAll deletes the keys
+	DeleteAll(ctx context.Context, keys []string) error
+	// Query runs a state query and returns a page of results with the token for the next page
+	Query(ctx context.Context, query stateQuery, metadata map[string]string) ([]*Entry[T], string, error)
+	// UpsertOp creates a transaction operation that saves the value of a key
+	UpsertOp(ctx context.Context, key string, value T, opts ...WriteOption) (Op, error)
+	// DeleteOp creates a transaction operation that deletes a key
+	DeleteOp(ctx context.Context, key string, opts ...WriteOption) (Op, error)
+	// Transact runs the operations atomically; they may come from any store on the same component. The state
+	// store enforces the ETags of the operations, but the sidecar reports a stale ETag like any other failure, so
+	// it returns ErrTransactionFailed rather than ErrETagMismatch, after the retries of the resiliency policy of
+	// the component. Callers that handle conflicts compare the ETags with the current entries before transacting.
+	Transact(ctx context.Context, ops ...Op) error
+	// Component returns the type, version and features of the state store component
+	Component(ctx context.Context) (*ComponentInfo, error)
+}
+
+// ComponentInfo describes a component loaded by the sidecar, as reported by the metadata API
+type ComponentInfo struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type stateOptions struct {
+	Concurrency string `json:"concurrency,omitempty"`
+	Consistency string `json:"consistency,omitempty"`
+}
+
+type stateItem struct {
+	Key      string            `json:"key"`
+	Value    json.RawMessage   `json:"value,omitempty"`
+	ETag     string            `json:"etag,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Options  *stateOptions     `json:"options,omitempty"`
+}
+
+type bulkGetRequest struct {
+	Keys        []string `json:"keys"`
+	Parallelism int      `json:"parallelism"`
+}
+
+type bulkGetItem struct {
+	Key      string            `json:"key"`
+	Data     json.RawMessage   `json:"data,omitempty"`
+	ETag     string            `json:"etag,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type queryResponse struct {
+	Results []bulkGetItem `json:"results"`
+	Token   string        `json:"token,omitempty"`
+}
+
+type transactionOperation struct {
+	Operation string    `json:"operation"`
+	Request   stateItem `json:"request"`
+}
+
+type transactionRequest struct {
+	Operations []transactionOperation `json:"operations"`
+}
+
+type metadataResponse struct {
+	Components []ComponentInfo `json:"components"`
+}
+
+type storeOptions struct {
+	codecs    []Codec
+	chunkSize int
+}
+
+// StoreOption configures how a store saves values
+type StoreOption func(*storeOptions)
+
+// WithCodec passes values through a codec; codecs are applied in order before values are saved,
+// and in reverse order after they are read
+func WithCodec(codec Codec) StoreOption {
+	return func(o *storeOptions) { o.codecs = append(o.codecs, codec) }
+}
+
+// WithChunkSize splits values larger than size bytes, after they pass through the codecs, into chunks
+// saved under their own keys, with a manifest under the key of the value
+func WithChunkSize(size int) StoreOption {
+	return func(o *storeOptions) { o.chunkSize = size }
+}
+
+// httpStore implements Store on top of the Dapr state HTTP API
+type httpStore[T any] struct {
+	client      *http.Client
+	storeName   string
+	stateURL    string
+	queryURL    string
+	metadataURL string
+	storeOptions
+}
+
+// newHTTPStore creates a store on the given state store component
+func newHTTPStore[T any](client *http.Client, daprURL, storeName string, opts ...StoreOption) Store[T] {
+	s := &httpStore[T]{
+		client:      client,
+		storeName:   storeName,
+		stateURL:    daprURL + "/v1.0/state/" + storeName,
+		queryURL:    daprURL + "/v1.0-alpha1/state/" + storeName + "/query",
+		metadataURL: daprURL + "/v1.0/metadata",
+	}
+	for _, opt := range opts {
+		opt(&s.storeOptions)
+	}
+	return s
+}
+
+func (s *httpStore[T]) Get(ctx context.Context, key string) (*Entry[T], error) {
+	res, err := s.do(ctx, http.MethodGet, s.stateURL+"/"+url.PathEscape(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError(res)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry[T]{Key: key, ETag: res.Header.Get("ETag")}
+	if expireTime := res.Header.Get("Metadata.ttlExpireTime"); expireTime != "" {
+		entry.Metadata = map[string]string{"ttlExpireTime": expireTime}
+	}
+	err = s.decode(ctx, key, data, &entry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return entry, nil
+}
+
+func (s *httpStore[T]) Put(ctx context.Context, key string, value T, opts ...WriteOption) error {
+	op, err := s.UpsertOp(ctx, key, value, opts...)
+	if err != nil {
+		return err
+	}
+	if len(op.related) > 0 {
+		// Save the chunks and the manifest atomically
+		err = s.checkETag(ctx, op)
+		if err != nil {
+			return err
+		}
+		return s.Transact(ctx, op)
+	}
+	return s.post(ctx, s.stateURL, []stateItem{op.stateItem()})
+}
+
+func (s *httpStore[T]) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	op, err := s.DeleteOp(ctx, key, opts...)
+	if err != nil {
+		return err
+	}
+	if len(op.related) > 0 {
+		err = s.checkETag(ctx, op)
+		if err != nil {
+			return err
+		}
+		return s.Transact(ctx, op)
+	}
+	o := op.opts
+	params := metadataParams(o.metadata)
+	if o.concurrency != "" {
+		params.Set("concurrency", string(o.concurrency))
+	}
+	header := http.Header{}
+	if o.etag != "" {
+		header.Set("If-Match", o.etag)
+	}
+	res, err := s.do(ctx, http.MethodDelete, s.stateURL+"/"+url.PathEscape(key)+"?"+params.Encode(), nil, header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return responseError(res)
+	}
+	return nil
+}
+
+func (s *httpStore[T]) List(ctx context.Context, keys []string) ([]*Entry[T], error) {
+	results, err := s.bulkGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.toEntries(ctx, results), nil
+}
+
+func (s *httpStore[T]) PutAll(ctx context.Context, entries []*Entry[T]) error {
+	items := make([]stateItem, 0, len(entries))
+	var chunked []Op
+	for _, entry := range entries {
+		op, err := s.UpsertOp(ctx, entry.Key, entry.Value, WithETag(entry.ETag), WithMetadata(entry.Metadata))
+		if err != nil {
+			return err
+		}
+		if len(op.related) > 0 {
+			chunked = append(chunked, op)
+			continue
+		}
+		items = append(items, op.stateItem())
+	}
+	if len(items) > 0 {
+		err := s.post(ctx, s.stateURL, items)
+		if err != nil {
+			return err
+		}
+	}
+	// Save each value split into chunks like Put does, in a transaction with its manifest and the deletes of
+	// the chunks left over from a larger previous value
+	for _, op := range chunked {
+		err := s.checkETag(ctx, op)
+		if err != nil {
+			return err
+		}
+		err = s.Transact(ctx, op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAll deletes the keys one at a time, with their chunks, as the HTTP API has no bulk delete
+func (s *httpStore[T]) DeleteAll(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		err := s.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *httpStore[T]) Query(ctx context.Context, query stateQuery, metadata map[string]string) ([]*Entry[T], string, error) {
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := s.do(ctx, http.MethodPost, s.queryURL+"?"+metadataParams(metadata).Encode(), bytes.NewReader(body), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", responseError(res)
+	}
+	var result queryResponse
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode query response: %w", err)
+	}
+	return s.toEntries(ctx, result.Results), result.Token, nil
+}
+
+func (s *httpStore[T]) UpsertOp(ctx context.Context, key string, value T, opts ...WriteOption) (Op, error) {
+	data, err := s.encode(ctx, value)
+	if err != nil {
+		return Op{}, fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	op := Op{key: key, value: data, opts: newWriteOptions(opts)}
+	if s.chunkSize == 0 {
+		return op, nil
+	}
+	// Look up the chunks of the previous value, so the ones the new value doesn't overwrite are deleted
+	counts, err := s.chunkCounts(ctx, []string{key})
+	if err != nil {
+		return Op{}, err
+	}
+	// Chunks are pieces of a JSON document, so they are stored as base64 encoded JSON strings
+	return op.withChunks(s.chunkSize, counts[key], func(chunk []byte) ([]byte, error) { return json.Marshal(chunk) })
+}
+
+func (s *httpStore[T]) DeleteOp(ctx context.Context, key string, opts ...WriteOption) (Op, error) {
+	op := Op{key: key, delete: true, opts: newWriteOptions(opts)}
+	if s.chunkSize == 0 {
+		return op, nil
+	}
+	counts, err := s.chunkCounts(ctx, []string{key})
+	if err != nil {
+		return Op{}, err
+	}
+	return op.withChunkDeletes(counts[key]), nil
+}
+
+func (s *httpStore[T]) Transact(ctx context.Context, ops ...Op) error {
+	operations := make([]transactionOperation, 0, len(ops))
+	for _, op := range ops {
+		for _, op := range op.expand() {
+			operation := transactionOperation{Operation: "upsert", Request: op.stateItem()}
+			if op.delete {
+				operation.Operation = "delete"
+			}
+			operations = append(operations, operation)
+		}
+	}
+	err := s.post(ctx, s.stateURL+"/transaction", transactionRequest{Operations: operations})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTransactionFailed, err)
+	}
+	return nil
+}
+
+// post sends a JSON request that the state store answers with 204 No Content on success
+func (s *httpStore[T]) post(ctx context.Context, reqURL string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodPost, reqURL, bytes.NewReader(body), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return responseError(res)
+	}
+	return nil
+}
+
+func (s *httpStore[T]) Component(ctx context.Context) (*ComponentInfo, error) {
+	res, err := s.do(ctx, http.MethodGet, s.metadataURL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError(res)
+	}
+	var metadata metadataResponse
+	err = json.NewDecoder(res.Body).Decode(&metadata)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range metadata.Components {
+		if c.Name == s.storeName {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: component %s", ErrNotFound, s.storeName)
+}
+
+func (s *httpStore[T]) do(ctx context.Context, method, reqURL string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return s.client.Do(req)
+}
+
+// encode returns the JSON document to store; values passed through codecs are binary, so they are stored
+// as base64 encoded JSON strings
+func (s *httpStore[T]) encode(ctx context.Context, value T) (json.RawMessage, error) {
+	plain, err := json.Marshal(value)
+	if err != nil || len(s.codecs) == 0 {
+		return plain, err
+	}
+	data := plain
+	for _, codec := range s.codecs {
+		data, err = codec.Encode(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Values the codecs left unchanged, such as small values that don't compress, are saved as plain JSON.
+	// JSON strings are always wrapped, so reads can tell them apart from encoded values.
+	if bytes.Equal(data, plain) && !isJSONString(plain) {
+		return plain, nil
+	}
+	return json.Marshal(data)
+}
+
+// isJSONString reports whether data is a JSON string, which is how encoded values are saved
+func isJSONString(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '"'
+}
+
+// bulkGet reads the keys in a single call
+func (s *httpStore[T]) bulkGet(ctx context.Context, keys []string) ([]bulkGetItem, error) {
+	body, _ := json.Marshal(bulkGetRequest{Keys: keys, Parallelism: len(keys)})
+	res, err := s.do(ctx, http.MethodPost, s.stateURL+"/bulk", bytes.NewReader(body), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError(res)
+	}
+	var results []bulkGetItem
+	err = json.NewDecoder(res.Body).Decode(&results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bulk get response: %w", err)
+	}
+	return results, nil
+}
+
+// checkETag returns ErrETagMismatch when the key of a conditional operation no longer has its ETag. Chunked
+// values are written in a transaction, which doesn't report stale ETags as such, so they are checked first.
+func (s *httpStore[T]) checkETag(ctx context.Context, op Op) error {
+	if op.opts.etag == "" {
+		return nil
+	}
+	res, err := s.do(ctx, http.MethodGet, s.stateURL+"/"+url.PathEscape(op.key), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return responseError(res)
+	}
+	if res.Header.Get("ETag") != op.opts.etag {
+		return fmt.Errorf("%w: %s", ErrETagMismatch, op.key)
+	}
+	return nil
+}
+
+// chunkCounts returns the number of chunks of the values that were split into chunks
+func (s *httpStore[T]) chunkCounts(ctx context.Context, keys []string) (map[string]int, error) {
+	results, err := s.bulkGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, result := range results {
+		if m, ok := parseManifest(result.Data); ok {
+			counts[result.Key] = m.Chunks
+		}
+	}
+	return counts, nil
+}
+
+// unchunk returns the value saved under key, reassembling it when it was split into chunks
+func (s *httpStore[T]) unchunk(ctx context.Context, key string, data json.RawMessage) (json.RawMessage, error) {
+	m, ok := parseManifest(data)
+	if !ok {
+		return data, nil
+	}
+	results, err := s.bulkGet(ctx, m.keys(key))
+	if err != nil {
+		return nil, err
+	}
+	// The bulk API doesn't keep the order of the keys
+	values := make(map[string]json.RawMessage, len(results))
+	for _, result := range results {
+		values[result.Key] = result.Data
+	}
+	chunks := make([][]byte, 0, m.Chunks)
+	for _, k := range m.keys(key) {
+		if len(values[k]) == 0 {
+			continue
+		}
+		var chunk []byte
+		err = json.Unmarshal(values[k], &chunk)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk %s: %w", k, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return m.join(key, chunks)
+}
+
+func (s *httpStore[T]) decode(ctx context.Context, key string, data json.RawMessage, value *T) error {
+	data, err := s.unchunk(ctx, key, data)
+	if err != nil {
+		return err
+	}
+	// Values that aren't JSON strings weren't encoded, for example because they were saved before a codec was
+	// enabled, so they are read as they are
+	if len(s.codecs) > 0 && isJSONString(data) {
+		var encoded []byte
+		err = json.Unmarshal(data, &encoded)
+		if err != nil {
+			return err
+		}
+		for i := len(s.codecs) - 1; i >= 0; i-- {
+			encoded, err = s.codecs[i].Decode(ctx, encoded)
+			if err != nil {
+				return err
+			}
+		}
+		data = encoded
+	}
+	return json.Unmarshal(data, value)
+}
+
+// stateItem converts the operation to the HTTP API representation
+func (op Op) stateItem() stateItem {
+	item := stateItem{Key: op.key, Value: op.value, ETag: op.opts.etag, Metadata: op.opts.metadata}
+	if op.opts.concurrency != "" {
+		item.Options = &stateOptions{Concurrency: string(op.opts.concurrency), Consistency: "strong"}
+	}
+	return item
+}
+
+func (s *httpStore[T]) toEntries(ctx context.Context, items []bulkGetItem) []*Entry[T] {
+	entries := make([]*Entry[T], 0, len(items))
+	for _, item := range items {
+		entry := &Entry[T]{Key: item.Key, ETag: item.ETag, Metadata: item.Metadata}
+		switch {
+		case item.Error != "":
+			entry.Err = errors.New(item.Error)
+		case len(item.Data) == 0:
+			entry.Err = fmt.Errorf("%w: %s", ErrNotFound, item.Key)
+		default:
+			entry.Err = s.decode(ctx, item.Key, item.Data, &entry.Value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func metadataParams(metadata map[string]string) url.Values {
+	params := url.Values{}
+	for k, v := range metadata {
+		params.Set("metadata."+k, v)
+	}
+	return params
+}
+
+// responseError reports 409 Conflict, returned by the sidecar for stale ETags, as ErrETagMismatch
+func responseError(res *http.Response) error {
+	msg, _ := io.ReadAll(res.Body)
+	err := fmt.Errorf("state store returned %s: %s", res.Status, bytes.TrimSpace(msg))
+	if res.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %w", ErrETagMismatch, err)
+	}
+	return err
+}
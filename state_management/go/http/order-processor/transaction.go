@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+const (
+	customerCount  = 3
+	latestOrderKey = "latest-order"
+)
+
+type customerOrders struct {
+	Customer string `json:"customer"`
+	OrderIds []int  `json:"orderIds"`
+}
+
+func customerOrdersKey(customer string) string {
+	return "customer-orders-" + customer
+}
+
+func customerFor(orderId int) string {
+	return "customer" + strconv.Itoa(orderId%customerCount+1)
+}
+
+// getCustomerOrders reads a customer's order list together with its ETag, which is empty for a new list
+func getCustomerOrders(ctx context.Context, lists Store[customerOrders], customer string) (*Entry[customerOrders], error) {
+	entry, err := lists.Get(ctx, customerOrdersKey(customer))
+	if errors.Is(err, ErrNotFound) {
+		return &Entry[customerOrders]{Key: customerOrdersKey(customer), Value: customerOrders{Customer: customer}}, nil
+	}
+	return entry, err
+}
+
+// runTransaction saves each order together with its secondary keys, the customer's order list and the
+// latest order pointer, in a single state transaction, so a crash can never leave dangling index keys
+func runTransaction(orders Store[Order], lists Store[customerOrders], orderCount int) {
+	ctx := context.Background()
+
+	for orderId := 1; orderId <= orderCount; orderId++ {
+		order := Order{OrderId: orderId, Customer: customerFor(orderId)}
+
+		// Read the customer's order list, keeping its ETag so a concurrent change to it aborts the transaction
+		list, err := getCustomerOrders(ctx, lists, order.Customer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		list.Value.OrderIds = append(list.Value.OrderIds, orderId)
+
+		orderOp, err := orders.UpsertOp(ctx, order.Key(), order)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listOp, err := lists.UpsertOp(ctx, list.Key, list.Value, WithETag(list.ETag))
+		if err != nil {
+			log.Fatal(err)
+		}
+		latestOp, err := orders.UpsertOp(ctx, latestOrderKey, order)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Save the order and update both indexes atomically
+		err = orders.Transact(ctx, orderOp, listOp, latestOp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Saved Order with indexes:", order)
+
+		// Read the order and its indexes back
+		results, err := orders.List(ctx, []string{order.Key(), latestOrderKey})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, result := range results {
+			fmt.Printf("Retrieved %s: %s\n", result.Key, result.Value)
+		}
+		list, err = lists.Get(ctx, list.Key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Retrieved %s: %v\n", list.Key, list.Value.OrderIds)
+	}
+
+	// Delete all the orders together with their indexes, again atomically
+	var ops []Op
+	for c := 1; c <= customerCount; c++ {
+		list, err := getCustomerOrders(ctx, lists, "customer"+strconv.Itoa(c))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(list.Value.OrderIds) == 0 {
+			continue
+		}
+		for _, orderId := range list.Value.OrderIds {
+			op, err := orders.DeleteOp(ctx, strconv.Itoa(orderId))
+			if err != nil {
+				log.Fatal(err)
+			}
+			ops = append(ops, op)
+		}
+		op, err := lists.DeleteOp(ctx, list.Key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ops = append(ops, op)
+	}
+	op, err := orders.DeleteOp(ctx, latestOrderKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ops = append(ops, op)
+
+	err = orders.Transact(ctx, ops...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Deleted %d keys in one transaction\n", len(ops))
+}
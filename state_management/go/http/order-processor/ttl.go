@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ttlPolicy returns the time to live of an order: TTL_SECONDS when it is set,
+// otherwise a value between 1 and 10 seconds computed from the order ID
+func ttlPolicy() func(orderId int) int {
+	if os.Getenv("TTL_SECONDS") != "" {
+		ttl := getEnvInt("TTL_SECONDS", 0)
+		return func(int) int { return ttl }
+	}
+	return func(orderId int) int { return orderId%10 + 1 }
+}
+
+// runTTL saves the orders with a ttlInSeconds metadata value instead of deleting them,
+// then polls the state store and reports each key as the store expires it
+func runTTL(orders Store[Order], orderCount int, ttlFor func(orderId int) int, pollInterval time.Duration) {
+	ctx := context.Background()
+
+	ttls := make(map[string]int, orderCount)
+	keys := make([]string, 0, orderCount)
+	entries := make([]*Entry[Order], 0, orderCount)
+	maxTTL := 0
+	for orderId := 1; orderId <= orderCount; orderId++ {
+		order := Order{OrderId: orderId}
+		key := order.Key()
+		ttl := ttlFor(orderId)
+		ttls[key] = ttl
+		maxTTL = max(maxTTL, ttl)
+		keys = append(keys, key)
+		entries = append(entries, &Entry[Order]{
+			Key:      key,
+			Value:    order,
+			Metadata: map[string]string{"ttlInSeconds": strconv.Itoa(ttl)},
+		})
+	}
+
+	start := time.Now()
+	err := orders.PutAll(ctx, entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Saved %d Orders with TTLs up to %ds\n", orderCount, maxTTL)
+
+	// Stores expire keys on their own schedule, so give them some slack past the longest TTL
+	deadline := start.Add(time.Duration(maxTTL)*time.Second + 10*pollInterval)
+	for len(keys) > 0 && time.Now().Before(deadline) {
+		time.Sleep(pollInterval)
+
+		results, err := orders.List(ctx, keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		elapsed := time.Since(start).Round(100 * time.Millisecond)
+		remaining := keys[:0]
+		for _, result := range results {
+			if result.Err == nil {
+				remaining = append(remaining, result.Key)
+				continue
+			}
+			if !errors.Is(result.Err, ErrNotFound) {
+				fmt.Printf("Failed to retrieve Order %s: %v\n", result.Key, result.Err)
+				remaining = append(remaining, result.Key)
+				continue
+			}
+			fmt.Printf("Order %s expired after %s (ttlInSeconds: %d)\n", result.Key, elapsed, ttls[result.Key])
+		}
+		keys = remaining
+	}
+
+	if len(keys) > 0 {
+		fmt.Printf("%d Orders did not expire in time, check that the state store supports TTLs\n", len(keys))
+		err = orders.DeleteAll(ctx, keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Println("All Orders expired")
+}
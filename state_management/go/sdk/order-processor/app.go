@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
 	"time"
 
@@ -18,31 +20,153 @@ func main() {
 		log.Fatal(err)
 	}
 
-	for i := 1; i <= 100; i++ {
-		orderId := i
-		order := `{"orderId":` + strconv.Itoa(orderId) + "}"
+	// Values are compressed when COMPRESSION is set, then encrypted with the crypto component when ENCRYPTION_KEY
+	// is set, and finally split into chunks when they are larger than CHUNK_SIZE bytes
+	var storeOpts []StoreOption
+	if codec := newCompressionCodec(os.Getenv("COMPRESSION")); codec != nil {
+		storeOpts = append(storeOpts, WithCodec(codec))
+	}
+	if os.Getenv("ENCRYPTION_KEY") != "" && os.Getenv("APP_MODE") == "query" {
+		log.Fatal("Query mode can't be used with ENCRYPTION_KEY, since the state store can't search encrypted values")
+	}
+	if codec := newCryptoCodec(client, os.Getenv("ENCRYPTION_KEY")); codec != nil {
+		storeOpts = append(storeOpts, WithCodec(codec))
+	}
+	chunkSize := 0
+	if os.Getenv("CHUNK_SIZE") != "" {
+		chunkSize = getEnvInt("CHUNK_SIZE", 0)
+		storeOpts = append(storeOpts, WithChunkSize(chunkSize))
+	}
+
+	// Every change to an order is recorded in its history when AUDIT_HISTORY is true
+	rawOrders := newSDKStore[json.RawMessage](client, stateStoreComponentName, storeOpts...)
+	history := &auditLog{
+		entries: newSDKStore[AuditEntry](client, stateStoreComponentName, storeOpts...),
+		heads:   newSDKStore[int](client, stateStoreComponentName, storeOpts...),
+	}
+	audit := os.Getenv("AUDIT_HISTORY") == "true"
+	if audit {
+		rawOrders = newAuditedStore(rawOrders, history)
+	}
+
+	// Orders are stored as versioned documents, which are upgraded to the current schema version when they are
+	// read, and written back when MIGRATION_WRITE_BACK is true
+	orders := newMigratingStore[Order](rawOrders, orderMigrations, os.Getenv("MIGRATION_WRITE_BACK") == "true")
+	orderCount := getEnvInt("ORDER_COUNT", 100)
+
+	// Orders are cached in memory when CACHE_SIZE is set, except in TTL mode, which polls the state store to see
+	// the orders expire, and in outbox mode, which uses another state store
+	mode := os.Getenv("APP_MODE")
+	var cache *cachedStore[Order]
+	if os.Getenv("CACHE_SIZE") != "" && mode != "ttl" && mode != "outbox" {
+		cache = newCachedStore(orders, getEnvInt("CACHE_SIZE", 0), newPublisher(client, cachePubSubName))
+		orders = cache
+		if mode != "serve" {
+			// The order service receives the invalidations itself
+			cache.listen(os.Getenv("APP_PORT"))
+		}
+	}
+
+	if mode == "outbox" {
+		// Events are published with the value as saved, so values aren't compressed, encrypted or split
+		orders = newSDKStore[Order](client, outboxStoreComponentName)
+	}
+	// Disable the mode when the state store doesn't support the features it relies on
+	var features []string
+	if audit && mode != "outbox" {
+		// History entries are written in the same transaction as the change
+		features = append(features, FeatureTransactional)
+	}
+	if chunkSize > 0 && mode != "outbox" {
+		// A value split into chunks is saved in one transaction with its manifest
+		features = append(features, FeatureTransactional)
+	}
+	if !checkFeatures(context.Background(), orders, mode, features...) {
+		return
+	}
+
+	switch mode {
+	case "", "single":
+		runSingle(orders, orderCount)
+	case "bulk":
+		runBulk(orders, orderCount, getEnvInt("BATCH_SIZE", 10))
+	case "etag":
+		runETag(orders, parseConcurrency(os.Getenv("CONCURRENCY")), getEnvInt("WRITERS", 5), getEnvInt("UPDATES", 10))
+	case "transaction":
+		runTransaction(orders, newSDKStore[customerOrders](client, stateStoreComponentName, storeOpts...), orderCount)
+	case "query":
+		runQuery(orders, orderCount, buildQuery())
+	case "ttl":
+		runTTL(orders, orderCount, ttlPolicy(), time.Duration(getEnvInt("POLL_INTERVAL_SECONDS", 1))*time.Second)
+	case "outbox":
+		runOutbox(orders, orderCount)
+	case "large":
+		runLarge(orders, orderCount, getEnvInt("ATTACHMENT_SIZE", 1<<20))
+	case "migrate":
+		runMigrate(rawOrders, orderMigrations, getEnvInt("PAGE_SIZE", 100))
+	case "history":
+		orderId := getEnvInt("ORDER_ID", 0)
+		if orderId == 0 {
+			log.Fatal("ORDER_ID is required in history mode")
+		}
+		runHistory(history, strconv.Itoa(orderId))
+	case "bench":
+		operations, duration := benchLimits()
+		runBench(orders, getEnvInt("WORKERS", 10), operations, duration)
+	case "serve":
+		appPort := os.Getenv("APP_PORT")
+		if appPort == "" {
+			appPort = "6008"
+		}
+		runServer(orders, newSDKStore[[]int](client, stateStoreComponentName, storeOpts...), appPort)
+	default:
+		log.Fatalf("Unknown APP_MODE %q", mode)
+	}
+
+	if cache != nil {
+		fmt.Println("Cache:", cache.Stats())
+	}
+}
+
+// runSingle saves, retrieves and deletes each order with one call per key
+func runSingle(orders Store[Order], orderCount int) {
+	for i := 1; i <= orderCount; i++ {
+		order := Order{OrderId: i}
 
 		// Save state into the state store
-		err = client.SaveState(context.Background(), stateStoreComponentName, strconv.Itoa(orderId), []byte(order), nil)
+		err := orders.Put(context.Background(), order.Key(), order)
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println("Saved Order:", string(order))
+		fmt.Println("Saved Order:", order)
 
 		// Get state from the state store
-		result, err := client.GetState(context.Background(), stateStoreComponentName, strconv.Itoa(orderId), nil)
+		result, err := orders.Get(context.Background(), order.Key())
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println("Retrieved Order:", string(result.Value))
+		fmt.Println("Retrieved Order:", result.Value)
 
 		// Delete state from the state store
-		err = client.DeleteState(context.Background(), stateStoreComponentName, strconv.Itoa(orderId), nil)
+		err = orders.Delete(context.Background(), order.Key())
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println("Deleted Order:", string(order))
+		fmt.Println("Deleted Order:", order)
 
-		time.Sleep(5000)
+		time.Sleep(time.Second)
+	}
+}
+
+// getEnvInt reads a positive integer from the environment, falling back to def when unset
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid value for %s: %q", name, value)
 	}
+	return n
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// AuditEntry records a save or a delete of a key; entries are never changed once written
+type AuditEntry struct {
+	Key          string        `json:"key"`
+	Sequence     int           `json:"sequence"`
+	Action       string        `json:"action"`
+	Actor        string        `json:"actor"`
+	Time         time.Time     `json:"time"`
+	PreviousETag string        `json:"previousETag,omitempty"`
+	Diff         []FieldChange `json:"diff,omitempty"`
+}
+
+// FieldChange is a top-level field of a document that a save or delete changed
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// The history of a key is a sequence of entries, with a head key holding the sequence number of the latest one
+func auditHeadKey(key string) string {
+	return key + "#history"
+}
+
+func auditEntryKey(key string, sequence int) string {
+	return key + "#history-" + strconv.Itoa(sequence)
+}
+
+// auditLog is the append-only history of the changes to the keys of a state store
+type auditLog struct {
+	entries Store[AuditEntry]
+	heads   Store[int]
+}
+
+type actorKey struct{}
+
+// withActor sets who makes the changes in the context, for example the user of a request
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// defaultActor returns AUDIT_ACTOR, or the app ID set by the Dapr CLI
+func defaultActor() string {
+	if actor := os.Getenv("AUDIT_ACTOR"); actor != "" {
+		return actor
+	}
+	if appId := os.Getenv("APP_ID"); appId != "" {
+		return appId
+	}
+	return "unknown"
+}
+
+// auditedStore appends an entry to the history of a key with every save or delete, in the same transaction
+// as the change, so the history can't miss a change or record one that didn't happen
+type auditedStore[T any] struct {
+	Store[T]
+	log   *auditLog
+	actor string
+}
+
+func newAuditedStore[T any](store Store[T], log *auditLog) Store[T] {
+	fmt.Println("Recording the history of every change")
+	return &auditedStore[T]{Store: store, log: log, actor: defaultActor()}
+}
+
+func (s *auditedStore[T]) Put(ctx context.Context, key string, value T, opts ...WriteOption) error {
+	op, err := s.UpsertOp(ctx, key, value, opts...)
+	if err != nil {
+		return err
+	}
+	return s.Transact(ctx, op)
+}
+
+func (s *auditedStore[T]) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	op, err := s.DeleteOp(ctx, key, opts...)
+	if err != nil {
+		return err
+	}
+	return s.Transact(ctx, op)
+}
+
+// PutAll saves the entries in a single transaction instead of a bulk call, to include their history
+func (s *auditedStore[T]) PutAll(ctx context.Context, entries []*Entry[T]) error {
+	ops := make([]Op, 0, len(entries))
+	for _, entry := range entries {
+		op, err := s.UpsertOp(ctx, entry.Key, entry.Value, WithETag(entry.ETag), WithMetadata(entry.Metadata))
+		if err != nil {
+			return err
+		}
+		ops = append(ops, op)
+	}
+	return s.Transact(ctx, ops...)
+}
+
+// DeleteAll deletes the keys in a single transaction, to include their history
+func (s *auditedStore[T]) DeleteAll(ctx context.Context, keys []string) error {
+	ops := make([]Op, 0, len(keys))
+	for _, key := range keys {
+		op, err := s.DeleteOp(ctx, key)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, op)
+	}
+	return s.Transact(ctx, ops...)
+}
+
+func (s *auditedStore[T]) UpsertOp(ctx context.Context, key string, value T, opts ...WriteOption) (Op, error) {
+	op, err := s.Store.UpsertOp(ctx, key, value, opts...)
+	if err != nil {
+		return Op{}, err
+	}
+	return s.withHistory(ctx, op, "save", &value)
+}
+
+func (s *auditedStore[T]) DeleteOp(ctx context.Context, key string, opts ...WriteOption) (Op, error) {
+	op, err := s.Store.DeleteOp(ctx, key, opts...)
+	if err != nil {
+		return Op{}, err
+	}
+	return s.withHistory(ctx, op, "delete", nil)
+}
+
+// withHistory adds the writes of the history entry and of the new head of the history to the operation.
+// Once the head exists, it is written with its ETag, so concurrent changes to a key can't take the same
+// sequence number. Dapr has no insert-only write, so the first changes to a key aren't protected: concurrent
+// first changes can both take sequence 1, and the last one written replaces the entry of the other.
+func (s *auditedStore[T]) withHistory(ctx context.Context, op Op, action string, value *T) (Op, error) {
+	var previous *T
+	entry := AuditEntry{Key: op.key, Action: action, Actor: s.actor, Time: time.Now().UTC()}
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		entry.Actor = actor
+	}
+	current, err := s.Store.Get(ctx, op.key)
+	switch {
+	case err == nil:
+		previous = &current.Value
+		entry.PreviousETag = current.ETag
+	case !errors.Is(err, ErrNotFound):
+		return Op{}, err
+	}
+	// The change is written in a transaction, which doesn't report a stale ETag as such, so a conditional
+	// change is checked against the current value here
+	if op.opts.etag != "" && (current == nil || current.ETag != op.opts.etag) {
+		return Op{}, fmt.Errorf("%w: %s", ErrETagMismatch, op.key)
+	}
+	entry.Diff = diff(previous, value)
+
+	head, err := s.log.heads.Get(ctx, auditHeadKey(op.key))
+	headOpts := []WriteOption{WithConcurrency(FirstWrite)}
+	switch {
+	case err == nil:
+		entry.Sequence = head.Value + 1
+		headOpts = append(headOpts, WithETag(head.ETag))
+	case errors.Is(err, ErrNotFound):
+		entry.Sequence = 1
+	default:
+		return Op{}, err
+	}
+
+	entryOp, err := s.log.entries.UpsertOp(ctx, auditEntryKey(op.key, entry.Sequence), entry)
+	if err != nil {
+		return Op{}, err
+	}
+	headOp, err := s.log.heads.UpsertOp(ctx, auditHeadKey(op.key), entry.Sequence, headOpts...)
+	if err != nil {
+		return Op{}, err
+	}
+	op.related = append(op.related, entryOp.expand()...)
+	op.related = append(op.related, headOp.expand()...)
+	return op, nil
+}
+
+// diff compares the top-level fields of the JSON encodings of two documents, either of which can be nil
+func diff[T any](previous, current *T) []FieldChange {
+	before, after := fields(previous), fields(current)
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		if !reflect.DeepEqual(before[name], after[name]) {
+			changes = append(changes, FieldChange{Field: name, Old: before[name], New: after[name]})
+		}
+	}
+	return changes
+}
+
+// fields returns the top-level fields of a document, or the whole document under an empty name
+// when it isn't a JSON object
+func fields[T any](doc *T) map[string]any {
+	if doc == nil {
+		return nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if json.Unmarshal(data, &m) == nil {
+		return m
+	}
+	var v any
+	_ = json.Unmarshal(data, &v)
+	return map[string]any{"": v}
+}
+
+// actorMiddleware records the user given in the X-Actor header as the author of the changes made by a request.
+// The header isn't authenticated: any caller that can reach the service can set it.
+func actorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := r.Header.Get("X-Actor"); actor != "" {
+			r = r.WithContext(withActor(r.Context(), actor))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runHistory prints the full history of a key, oldest change first
+func runHistory(history *auditLog, key string) {
+	ctx := context.Background()
+
+	head, err := history.heads.Get(ctx, auditHeadKey(key))
+	if errors.Is(err, ErrNotFound) {
+		fmt.Printf("No history for Order %s\n", key)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("History of Order %s: %d changes\n", key, head.Value)
+
+	const batchSize = 100
+	for first := 1; first <= head.Value; first += batchSize {
+		keys := make([]string, 0, batchSize)
+		for sequence := first; sequence <= min(first+batchSize-1, head.Value); sequence++ {
+			keys = append(keys, auditEntryKey(key, sequence))
+		}
+		results, err := history.entries.List(ctx, keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The bulk API doesn't keep the order of the keys
+		slices.SortFunc(results, func(a, b *Entry[AuditEntry]) int { return a.Value.Sequence - b.Value.Sequence })
+		for _, result := range results {
+			if result.Err != nil {
+				fmt.Printf("Failed to retrieve %s: %v\n", result.Key, result.Err)
+				continue
+			}
+			printAuditEntry(result.Value)
+		}
+	}
+}
+
+func printAuditEntry(entry AuditEntry) {
+	previousETag := entry.PreviousETag
+	if previousETag == "" {
+		previousETag = "none"
+	}
+	fmt.Printf("#%d %s %s by %s (previous ETag: %s)\n",
+		entry.Sequence, entry.Time.Format(time.RFC3339), entry.Action, entry.Actor, previousETag)
+	for _, change := range entry.Diff {
+		old, _ := json.Marshal(change.Old)
+		after, _ := json.Marshal(change.New)
+		fmt.Printf("    %s: %s -> %s\n", change.Field, old, after)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var benchOperations = []string{"save", "get", "delete"}
+
+// Upper bounds of the latency histogram buckets; the last bucket has no upper bound
+var latencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	20 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+// latencyRecorder collects the latency of every call, per operation
+type latencyRecorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func (l *latencyRecorder) record(operation string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.errors[operation]++
+		return
+	}
+	l.latencies[operation] = append(l.latencies[operation], elapsed)
+}
+
+// benchLimits returns the number of iterations to run from OPERATIONS, or, when it isn't set,
+// the duration to run for from DURATION_SECONDS
+func benchLimits() (int, time.Duration) {
+	if os.Getenv("OPERATIONS") != "" {
+		return getEnvInt("OPERATIONS", 0), 0
+	}
+	return 0, time.Duration(getEnvInt("DURATION_SECONDS", 10)) * time.Second
+}
+
+// runBench runs workers concurrent save, get and delete loops on distinct keys, until operations iterations
+// have run or, when operations is 0, until duration has elapsed, then prints latency percentiles and throughput
+func runBench(orders Store[Order], workers, operations int, duration time.Duration) {
+	ctx := context.Background()
+	if operations == 0 {
+		fmt.Printf("Running benchmark with %d workers for %s\n", workers, duration)
+	} else {
+		fmt.Printf("Running benchmark with %d workers for %d iterations\n", workers, operations)
+	}
+
+	recorder := &latencyRecorder{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	var iterations atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	for w := 1; w <= workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				n := iterations.Add(1)
+				if operations > 0 && n > int64(operations) || operations == 0 && time.Now().After(deadline) {
+					return
+				}
+				order := Order{OrderId: int(n)}
+				key := "bench-" + strconv.Itoa(worker) + "-" + order.Key()
+
+				t := time.Now()
+				err := orders.Put(ctx, key, order)
+				recorder.record("save", t, err)
+
+				t = time.Now()
+				_, err = orders.Get(ctx, key)
+				recorder.record("get", t, err)
+
+				t = time.Now()
+				err = orders.Delete(ctx, key)
+				recorder.record("delete", t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("Completed in %s\n\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("%-8s %8s %8s %10s %10s %10s %10s %10s\n", "op", "count", "errors", "ops/s", "p50", "p95", "p99", "max")
+	for _, op := range benchOperations {
+		latencies := recorder.latencies[op]
+		slices.Sort(latencies)
+		fmt.Printf("%-8s %8d %8d %10.1f %10s %10s %10s %10s\n", op, len(latencies), recorder.errors[op],
+			float64(len(latencies))/elapsed.Seconds(),
+			percentile(latencies, 50), percentile(latencies, 95), percentile(latencies, 99), percentile(latencies, 100))
+	}
+	for _, op := range benchOperations {
+		fmt.Printf("\n%s latency histogram\n", op)
+		printHistogram(recorder.latencies[op])
+	}
+}
+
+// percentile returns the p-th percentile of the sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	return sorted[max(i, 0)].Round(10 * time.Microsecond)
+}
+
+func printHistogram(latencies []time.Duration) {
+	const width = 40
+	counts := make([]int, len(latencyBuckets)+1)
+	for _, l := range latencies {
+		i, _ := slices.BinarySearch(latencyBuckets, l)
+		counts[i]++
+	}
+	most := slices.Max(counts)
+	for i, count := range counts {
+		label := ">" + latencyBuckets[len(latencyBuckets)-1].String()
+		if i < len(latencyBuckets) {
+			label = "<=" + latencyBuckets[i].String()
+		}
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("#", count*width/most)
+		}
+		fmt.Printf("  %8s %8d %s\n", label, count, bar)
+	}
+}
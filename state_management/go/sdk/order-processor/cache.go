@@ -0,0 +1,295 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Invalidations are published on the pub/sub component of the pub_sub quickstart
+	cachePubSubName = "orderpubsub"
+	cacheTopic      = "order-cache-invalidations"
+)
+
+// cacheInvalidation tells the other replicas to evict the keys written by a replica
+type cacheInvalidation struct {
+	Replica string   `json:"replica"`
+	Keys    []string `json:"keys"`
+}
+
+// CacheStats counts the cache lookups and evictions since the app started
+type CacheStats struct {
+	Size          int     `json:"size"`
+	Hits          int     `json:"hits"`
+	Misses        int     `json:"misses"`
+	HitRatio      float64 `json:"hitRatio"`
+	Evictions     int     `json:"evictions"`
+	Invalidations int     `json:"invalidations"`
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d entries, %d hits, %d misses (%.1f%% hit ratio), %d evictions, %d invalidations",
+		s.Size, s.Hits, s.Misses, s.HitRatio*100, s.Evictions, s.Invalidations)
+}
+
+// cachedStore is a read-through cache in front of a store. It keeps the most recently used entries in memory,
+// evicts the keys it writes, and publishes them so that the other replicas evict them too.
+type cachedStore[T any] struct {
+	Store[T]
+	publish  func(ctx context.Context, topic string, data any) error
+	replica  string
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation changes on every invalidation, so a read that raced with a write doesn't cache the old value
+	generation int
+	stats      CacheStats
+}
+
+// newCachedStore caches up to capacity entries of the store; publish sends the invalidations to the other replicas
+func newCachedStore[T any](store Store[T], capacity int, publish func(ctx context.Context, topic string, data any) error) *cachedStore[T] {
+	hostname, _ := os.Hostname()
+	fmt.Printf("Caching up to %d state entries\n", capacity)
+	return &cachedStore[T]{
+		Store:    store,
+		publish:  publish,
+		replica:  fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *cachedStore[T]) Get(ctx context.Context, key string) (*Entry[T], error) {
+	entry, generation, ok := c.lookup(key)
+	if ok {
+		return entry, nil
+	}
+	entry, err := c.Store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.add(entry, generation)
+	return entry, nil
+}
+
+func (c *cachedStore[T]) List(ctx context.Context, keys []string) ([]*Entry[T], error) {
+	entries := make([]*Entry[T], 0, len(keys))
+	var missing []string
+	generation := 0
+	for _, key := range keys {
+		entry, g, ok := c.lookup(key)
+		if ok {
+			entries = append(entries, entry)
+			continue
+		}
+		if len(missing) == 0 {
+			generation = g
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return entries, nil
+	}
+	results, err := c.Store.List(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			c.add(result, generation)
+		}
+	}
+	return append(entries, results...), nil
+}
+
+func (c *cachedStore[T]) Put(ctx context.Context, key string, value T, opts ...WriteOption) error {
+	err := c.Store.Put(ctx, key, value, opts...)
+	return c.written(ctx, err, key)
+}
+
+func (c *cachedStore[T]) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	err := c.Store.Delete(ctx, key, opts...)
+	return c.written(ctx, err, key)
+}
+
+func (c *cachedStore[T]) PutAll(ctx context.Context, entries []*Entry[T]) error {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	err := c.Store.PutAll(ctx, entries)
+	return c.written(ctx, err, keys...)
+}
+
+func (c *cachedStore[T]) DeleteAll(ctx context.Context, keys []string) error {
+	err := c.Store.DeleteAll(ctx, keys)
+	return c.written(ctx, err, keys...)
+}
+
+func (c *cachedStore[T]) Transact(ctx context.Context, ops ...Op) error {
+	keys := make([]string, 0, len(ops))
+	for _, op := range ops {
+		keys = append(keys, op.key)
+	}
+	err := c.Store.Transact(ctx, ops...)
+	return c.written(ctx, err, keys...)
+}
+
+// written evicts the keys after a write, even a failed one, since a write rejected because of a stale ETag
+// means the cached entry is stale; the other replicas are only told about successful writes
+func (c *cachedStore[T]) written(ctx context.Context, err error, keys ...string) error {
+	c.invalidate(keys)
+	if err != nil {
+		return err
+	}
+	pubErr := c.publish(ctx, cacheTopic, cacheInvalidation{Replica: c.replica, Keys: keys})
+	if pubErr != nil {
+		// The write succeeded, so don't fail it; the other replicas serve stale entries until they are evicted
+		log.Println("Failed to publish cache invalidation:", pubErr.Error())
+	}
+	return nil
+}
+
+// lookup returns the cached entry for the key, or the current generation on a miss
+func (c *cachedStore[T]) lookup(key string) (*Entry[T], int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if ok && expired(elem.Value.(*Entry[T])) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, c.generation, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+	// Return a copy, so callers can't change the cached entry
+	entry := *elem.Value.(*Entry[T])
+	return &entry, 0, true
+}
+
+// expired reports whether the state store expired the entry since it was read, from the ttlExpireTime metadata
+// that stores supporting TTLs return with values saved with a ttlInSeconds
+func expired[T any](entry *Entry[T]) bool {
+	expireTime, ok := entry.Metadata["ttlExpireTime"]
+	if !ok {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, expireTime)
+	return err == nil && !time.Now().Before(t)
+}
+
+// add caches the entry unless a key was invalidated since the generation was read
+func (c *cachedStore[T]) add(entry *Entry[T], generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	cached := *entry
+	if elem, ok := c.entries[entry.Key]; ok {
+		elem.Value = &cached
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.Key] = c.lru.PushFront(&cached)
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Entry[T]).Key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *cachedStore[T]) invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// Stats returns the cache statistics
+func (c *cachedStore[T]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// routes registers the subscription to the invalidations published by the other replicas, and the statistics
+func (c *cachedStore[T]) routes(r *mux.Router) {
+	r.HandleFunc("/dapr/subscribe", subscribeCacheInvalidations).Methods("GET")
+	r.HandleFunc("/cache/invalidate", c.handleInvalidation).Methods("POST")
+	r.HandleFunc("/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, "", c.Stats())
+	}).Methods("GET")
+}
+
+// listen receives the invalidations in the modes that don't serve requests. Dapr only delivers them to an app
+// with a port, so without APP_PORT the cache only sees the writes of this replica.
+func (c *cachedStore[T]) listen(appPort string) {
+	if appPort == "" {
+		fmt.Println("Not receiving cache invalidations from the other replicas, run with --app-port to receive them")
+		return
+	}
+	r := mux.NewRouter()
+	r.Use(appTokenMiddleware(os.Getenv("APP_API_TOKEN")))
+	c.routes(r)
+	go func() {
+		err := http.ListenAndServe(":"+appPort, r)
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Error receiving cache invalidations:", err.Error())
+		}
+	}()
+}
+
+// subscribeCacheInvalidations handles the /dapr/subscribe route, which Dapr invokes to get the list of subscribed topics
+func subscribeCacheInvalidations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, "", []map[string]string{
+		{"pubsubname": cachePubSubName, "topic": cacheTopic, "route": "cache/invalidate"},
+	})
+}
+
+// handleInvalidation evicts the keys written by the other replicas, which Dapr delivers as cloud events
+func (c *cachedStore[T]) handleInvalidation(w http.ResponseWriter, r *http.Request) {
+	var event struct {
+		Data cacheInvalidation `json:"data"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&event)
+	if err != nil {
+		// Drop the event instead of retrying it, since it can never be decoded
+		log.Println("Invalid cache invalidation:", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if event.Data.Replica != c.replica {
+		c.invalidate(event.Data.Keys)
+	}
+	w.WriteHeader(http.StatusOK)
+}
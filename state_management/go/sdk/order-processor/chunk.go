@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// chunkManifest is saved under the key of a value that was split into chunks, in place of the value
+type chunkManifest struct {
+	Chunks int `json:"$chunks"`
+	Size   int `json:"$size"`
+}
+
+// chunkManifestPrefix starts every manifest, so manifests can be told apart from values without decoding them
+const chunkManifestPrefix = `{"$chunks":`
+
+func chunkKey(key string, i int) string {
+	return key + "#chunk-" + strconv.Itoa(i)
+}
+
+// parseManifest returns the manifest when the stored value is one
+func parseManifest(data []byte) (*chunkManifest, bool) {
+	if !bytes.HasPrefix(data, []byte(chunkManifestPrefix)) {
+		return nil, false
+	}
+	var m chunkManifest
+	if json.Unmarshal(data, &m) != nil {
+		return nil, false
+	}
+	return &m, true
+}
+
+// keys returns the keys of the chunks of the value saved under key
+func (m *chunkManifest) keys(key string) []string {
+	keys := make([]string, m.Chunks)
+	for i := range keys {
+		keys[i] = chunkKey(key, i)
+	}
+	return keys
+}
+
+// join reassembles the value from its chunks, checking that none is missing
+func (m *chunkManifest) join(key string, chunks [][]byte) ([]byte, error) {
+	data := bytes.Join(chunks, nil)
+	if len(chunks) != m.Chunks || len(data) != m.Size {
+		return nil, fmt.Errorf("incomplete chunks for %s: got %d chunks and %d bytes, want %d chunks and %d bytes",
+			key, len(chunks), len(data), m.Chunks, m.Size)
+	}
+	return data, nil
+}
+
+// withChunks splits the value of an upsert that is larger than size bytes into chunks saved under their own
+// keys, leaving a manifest under the key. Chunks of the previous value that the new value doesn't overwrite are
+// deleted. The chunks share the metadata of the value, so they expire together, while the ETag only applies to
+// the manifest. chunkValue converts a chunk to the representation of the store.
+func (op Op) withChunks(size, previousChunks int, chunkValue func([]byte) ([]byte, error)) (Op, error) {
+	data := op.value
+	written := 0
+	if size > 0 && len(data) > size {
+		manifest := chunkManifest{Chunks: (len(data) + size - 1) / size, Size: len(data)}
+		written = manifest.Chunks
+		for i := 0; i < manifest.Chunks; i++ {
+			value, err := chunkValue(data[i*size : min((i+1)*size, len(data))])
+			if err != nil {
+				return op, err
+			}
+			op.related = append(op.related, Op{key: chunkKey(op.key, i), value: value, opts: writeOptions{metadata: op.opts.metadata}})
+		}
+		var err error
+		op.value, err = json.Marshal(manifest)
+		if err != nil {
+			return op, err
+		}
+	}
+	for i := written; i < previousChunks; i++ {
+		op.related = append(op.related, Op{key: chunkKey(op.key, i), delete: true})
+	}
+	return op, nil
+}
+
+// withChunkDeletes adds the deletes of the chunks of the value to a delete
+func (op Op) withChunkDeletes(previousChunks int) Op {
+	for i := 0; i < previousChunks; i++ {
+		op.related = append(op.related, Op{key: chunkKey(op.key, i), delete: true})
+	}
+	return op
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+const (
+	// Name of the crypto component to use, shared with the cryptography quickstart
+	CryptoComponentName = "localstorage"
+	// Name of the RSA private key to use
+	RSAKeyName = "rsa-private-key.pem"
+	// Name of the symmetric (AES) key to use
+	SymmetricKeyName = "symmetric-key-256"
+)
+
+// cryptoCodec encrypts values with the Dapr cryptography building block before they are saved,
+// and decrypts them after they are read, so the state store only ever holds ciphertext
+type cryptoCodec struct {
+	client           dapr.Client
+	keyName          string
+	keyWrapAlgorithm string
+}
+
+// newCryptoCodec returns the codec for the ENCRYPTION_KEY setting: "rsa" for the RSA key,
+// "aes" for the symmetric key, or nil when values should be stored in clear text
+func newCryptoCodec(client dapr.Client, key string) Codec {
+	switch key {
+	case "":
+		return nil
+	case "rsa":
+		fmt.Println("Encrypting state values with key", RSAKeyName)
+		return &cryptoCodec{client: client, keyName: RSAKeyName, keyWrapAlgorithm: "RSA"}
+	case "aes":
+		fmt.Println("Encrypting state values with key", SymmetricKeyName)
+		return &cryptoCodec{client: client, keyName: SymmetricKeyName, keyWrapAlgorithm: "AES"}
+	default:
+		log.Fatalf("Invalid value for ENCRYPTION_KEY: %q", key)
+		return nil
+	}
+}
+
+func (c *cryptoCodec) Encode(ctx context.Context, data []byte) ([]byte, error) {
+	encStream, err := c.client.Encrypt(ctx, bytes.NewReader(data), dapr.EncryptOptions{
+		ComponentName:    CryptoComponentName,
+		KeyName:          c.keyName,
+		KeyWrapAlgorithm: c.keyWrapAlgorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(encStream)
+}
+
+func (c *cryptoCodec) Decode(ctx context.Context, data []byte) ([]byte, error) {
+	decStream, err := c.client.Decrypt(ctx, bytes.NewReader(data), dapr.DecryptOptions{
+		ComponentName: CryptoComponentName,
+		KeyName:       c.keyName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decStream)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxUpdateAttempts = 10
+
+// errConflict is returned when an update keeps losing to concurrent writers
+var errConflict = errors.New("too many conflicting updates")
+
+// updateOrder performs a read-modify-write of an order, passing the ETag returned by Get back into Put.
+// With first-write concurrency a stale ETag is rejected by the state store, and the update is retried with backoff.
+// onConflict is called for every rejected attempt.
+func updateOrder(ctx context.Context, orders Store[Order], key string, concurrency Concurrency, mutate func(*Order), onConflict func()) error {
+	backoff := 10 * time.Millisecond
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		entry, err := orders.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		mutate(&entry.Value)
+		err = orders.Put(ctx, key, entry.Value, WithETag(entry.ETag), WithConcurrency(concurrency))
+		if !errors.Is(err, ErrETagMismatch) {
+			return err
+		}
+
+		// Another writer saved the order since we read it: back off with jitter and try again
+		onConflict()
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+		backoff = min(backoff*2, time.Second)
+	}
+	return errConflict
+}
+
+// runETag runs writers concurrent read-modify-write loops against a single order, each incrementing its update count.
+// With first-write concurrency every conflict is detected and retried, so no update is lost.
+// With last-write concurrency the ETag is ignored and concurrent updates overwrite each other.
+func runETag(orders Store[Order], concurrency Concurrency, writers, updates int) {
+	ctx := context.Background()
+	order := Order{OrderId: 1}
+
+	err := orders.Put(ctx, order.Key(), order)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Saved Order:", order)
+	fmt.Printf("Starting %d writers with %d updates each using %s concurrency\n", writers, updates, concurrency)
+
+	var conflicts, failures atomic.Int64
+	var wg sync.WaitGroup
+	for w := 1; w <= writers; w++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for u := 0; u < updates; u++ {
+				err := updateOrder(ctx, orders, order.Key(), concurrency,
+					func(o *Order) { o.Updates++ },
+					func() { conflicts.Add(1) },
+				)
+				if err != nil {
+					failures.Add(1)
+					fmt.Printf("Writer %d failed to update Order %s: %v\n", writer, order.Key(), err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	result, err := orders.Get(ctx, order.Key())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Retrieved Order:", result.Value)
+
+	expected := writers*updates - int(failures.Load())
+	fmt.Printf("Conflicts detected: %d, failed updates: %d\n", conflicts.Load(), failures.Load())
+	fmt.Printf("Updates applied: %d, lost: %d\n", result.Value.Updates, expected-result.Value.Updates)
+
+	// Delete the order only if nobody changed it since it was read
+	err = orders.Delete(ctx, order.Key(), WithETag(result.ETag), WithConcurrency(concurrency))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Deleted Order:", result.Value)
+}
+
+// parseConcurrency validates the CONCURRENCY setting
+func parseConcurrency(value string) Concurrency {
+	switch Concurrency(value) {
+	case "":
+		return FirstWrite
+	case FirstWrite, LastWrite:
+		return Concurrency(value)
+	default:
+		log.Fatalf("Invalid value for CONCURRENCY: %q", value)
+		return ""
+	}
+}
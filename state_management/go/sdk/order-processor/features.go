@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// Features that state store components report through the metadata API
+const (
+	FeatureETag          = "ETAG"
+	FeatureTransactional = "TRANSACTIONAL"
+	FeatureQueryAPI      = "QUERY_API"
+	FeatureTTL           = "TTL"
+)
+
+// modeFeatures lists the state store features each mode relies on; the other modes work with any state store
+var modeFeatures = map[string][]string{
+	"etag":        {FeatureETag},
+	"transaction": {FeatureTransactional, FeatureETag},
+	"query":       {FeatureQueryAPI},
+	"ttl":         {FeatureTTL},
+	"outbox":      {FeatureTransactional},
+	"serve":       {FeatureTransactional, FeatureETag},
+	"migrate":     {FeatureQueryAPI, FeatureETag},
+}
+
+func (c *ComponentInfo) String() string {
+	features := "no features"
+	if len(c.Capabilities) > 0 {
+		features = "features: " + strings.Join(c.Capabilities, ", ")
+	}
+	return fmt.Sprintf("%s (%s/%s, %s)", c.Name, c.Type, c.Version, features)
+}
+
+// checkFeatures looks up the state store in the sidecar metadata and reports whether it supports every
+// feature the mode relies on, as well as the extra features enabled options rely on. When it doesn't, the
+// mode is disabled with a message naming the missing features, instead of failing on the first unsupported call.
+func checkFeatures[T any](ctx context.Context, store Store[T], mode string, extra ...string) bool {
+	info, err := store.Component(ctx)
+	if errors.Is(err, ErrNotFound) {
+		log.Fatalf("Couldn't find the state store in the sidecar metadata, check the resources path: %v", err)
+	}
+	if err != nil {
+		// Without metadata, let the state store reject what it doesn't support
+		fmt.Println("Couldn't read the state store features, assuming they are supported:", err)
+		return true
+	}
+	fmt.Println("Using state store", info)
+
+	var missing []string
+	for _, feature := range append(modeFeatures[mode], extra...) {
+		if !slices.Contains(info.Capabilities, feature) && !slices.Contains(missing, feature) {
+			missing = append(missing, feature)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("The %s mode is disabled: state store %s doesn't support %s\n", mode, info.Name, strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
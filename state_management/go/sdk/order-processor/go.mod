@@ -2,7 +2,12 @@ module order_processor_sdk_example
 
 go 1.21.8
 
-require github.com/dapr/go-sdk v1.10.0
+require (
+	github.com/dapr/go-sdk v1.10.0
+	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.17.4
+	google.golang.org/grpc v1.62.0
+)
 
 require (
 	github.com/dapr/dapr v1.13.0-rc.7 // indirect
@@ -15,7 +20,6 @@ require (
 	golang.org/x/sys v0.17.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240205150955-31a09d347014 // indirect
-	google.golang.org/grpc v1.62.0 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
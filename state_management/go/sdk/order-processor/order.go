@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Order is the document stored in the state store for each order
+type Order struct {
+	OrderId int `json:"orderId"`
+	// Version is the schema version of the stored document, which the store sets on write
+	Version  int    `json:"version,omitempty"`
+	Customer string `json:"customer,omitempty"`
+	Updates  int    `json:"updates,omitempty"`
+	// Status is added in version 3, which sets it to created for the orders saved before
+	Status string `json:"status,omitempty"`
+	// Attachments can make orders much larger than the limit of some state stores on the size of a value
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a document attached to an order, such as an invoice
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	// Size is added in version 2, so attachments can be listed without decoding their data
+	Size int    `json:"size"`
+	Data []byte `json:"data"`
+}
+
+// orderMigrations upgrades the stored orders to the current schema version, adding one version per migration
+var orderMigrations = Migrations{
+	// Version 1 to 2: record the size of each attachment
+	func(doc map[string]any) error {
+		attachments, _ := doc["attachments"].([]any)
+		for _, a := range attachments {
+			attachment, ok := a.(map[string]any)
+			if !ok {
+				return errors.New("attachment isn't an object")
+			}
+			data, _ := attachment["data"].(string)
+			decoded, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return err
+			}
+			attachment["size"] = len(decoded)
+		}
+		return nil
+	},
+	// Version 2 to 3: add the status of the order
+	func(doc map[string]any) error {
+		if _, ok := doc["status"]; !ok {
+			doc["status"] = "created"
+		}
+		return nil
+	},
+}
+
+func (o Order) Key() string {
+	return strconv.Itoa(o.OrderId)
+}
+
+func (o Order) String() string {
+	data, _ := json.Marshal(o)
+	return string(data)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"context"
+
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+// newPublisher returns a function that publishes JSON events to the topics of a pub/sub component
+func newPublisher(client dapr.Client, pubsubName string) func(ctx context.Context, topic string, data any) error {
+	return func(ctx context.Context, topic string, data any) error {
+		return client.PublishEvent(ctx, pubsubName, topic, data)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+)
+
+// Query API results are only returned for values that are stored as JSON documents
+var jsonContentType = map[string]string{"contentType": "application/json"}
+
+type querySort struct {
+	Key   string `json:"key"`
+	Order string `json:"order,omitempty"`
+}
+
+type queryPage struct {
+	Limit int    `json:"limit"`
+	Token string `json:"token,omitempty"`
+}
+
+type stateQuery struct {
+	Filter map[string]any `json:"filter,omitempty"`
+	Sort   []querySort    `json:"sort,omitempty"`
+	Page   queryPage      `json:"page"`
+}
+
+// queryMetadata returns the metadata sent with each query, including the QUERY_INDEX_NAME
+// that the Redis state store needs to know which of its queryIndexes to search
+func queryMetadata() map[string]string {
+	meta := maps.Clone(jsonContentType)
+	if indexName := os.Getenv("QUERY_INDEX_NAME"); indexName != "" {
+		meta["queryIndexName"] = indexName
+	}
+	return meta
+}
+
+// buildQuery returns the query from the QUERY environment variable, or builds one that finds the orders with an
+// orderId greater than QUERY_MIN_ORDER_ID, sorted by orderId in SORT_ORDER, PAGE_SIZE results at a time
+func buildQuery() stateQuery {
+	var query stateQuery
+	if raw := os.Getenv("QUERY"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &query)
+		if err != nil {
+			log.Fatalf("Invalid value for QUERY: %v", err)
+		}
+		return query
+	}
+
+	sortOrder := os.Getenv("SORT_ORDER")
+	if sortOrder == "" {
+		sortOrder = "DESC"
+	}
+	return stateQuery{
+		Filter: map[string]any{"GT": map[string]any{"orderId": getEnvInt("QUERY_MIN_ORDER_ID", 50)}},
+		Sort:   []querySort{{Key: "orderId", Order: sortOrder}},
+		Page:   queryPage{Limit: getEnvInt("PAGE_SIZE", 10)},
+	}
+}
+
+// runQuery saves the orders as JSON documents, then searches them with the state query API,
+// following the pagination token until all the pages of results have been read
+func runQuery(orders Store[Order], orderCount int, query stateQuery) {
+	ctx := context.Background()
+
+	entries := make([]*Entry[Order], 0, orderCount)
+	keys := make([]string, 0, orderCount)
+	for orderId := 1; orderId <= orderCount; orderId++ {
+		order := Order{OrderId: orderId, Customer: customerFor(orderId)}
+		keys = append(keys, order.Key())
+		entries = append(entries, &Entry[Order]{Key: order.Key(), Value: order, Metadata: jsonContentType})
+	}
+	err := orders.PutAll(ctx, entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Saved %d Orders\n", orderCount)
+
+	for page := 1; ; page++ {
+		q, _ := json.Marshal(query)
+		fmt.Println("Running query:", string(q))
+
+		// Query the state store for one page of results
+		results, token, err := orders.Query(ctx, query, queryMetadata())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Page %d: %d results\n", page, len(results))
+		for _, result := range results {
+			if result.Err != nil {
+				fmt.Printf("Failed to retrieve Order %s: %v\n", result.Key, result.Err)
+				continue
+			}
+			fmt.Println("Found Order:", result.Value)
+		}
+
+		// An empty token, or a short page, means there are no more results
+		if token == "" || len(results) < query.Page.Limit {
+			break
+		}
+		query.Page.Token = token
+	}
+
+	err = orders.DeleteAll(ctx, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Deleted %d Orders\n", orderCount)
+}
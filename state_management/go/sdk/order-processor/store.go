@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrNotFound is returned when a key doesn't exist in the state store
+	ErrNotFound = errors.New("key not found")
+	// ErrETagMismatch is returned when a write is rejected because the key changed since its ETag was read
+	ErrETagMismatch = errors.New("etag mismatch")
+	// ErrTransactionFailed is returned when a state transaction fails, including when one of its ETags is stale
+	ErrTransactionFailed = errors.New("transaction failed")
+)
+
+// Entry is a typed value stored under a key, together with its ETag and metadata
+type Entry[T any] struct {
+	Key      string
+	Value    T
+	ETag     string
+	Metadata map[string]string
+	// Err is set by List for the keys that could not be read
+	Err error
+}
+
+// Concurrency is the concurrency mode used when writing with an ETag
+type Concurrency string
+
+const (
+	FirstWrite Concurrency = "first-write"
+	LastWrite  Concurrency = "last-write"
+)
+
+type writeOptions struct {
+	etag        string
+	metadata    map[string]string
+	concurrency Concurrency
+}
+
+// WriteOption configures a single write or delete
+type WriteOption func(*writeOptions)
+
+// WithETag makes the write conditional on the key still having the given ETag
+func WithETag(etag string) WriteOption {
+	return func(o *writeOptions) { o.etag = etag }
+}
+
+// WithMetadata passes metadata, such as ttlInSeconds, to the state store
+func WithMetadata(metadata map[string]string) WriteOption {
+	return func(o *writeOptions) { o.metadata = metadata }
+}
+
+// WithConcurrency sets the concurrency mode; writes with an ETag default to first-write
+func WithConcurrency(concurrency Concurrency) WriteOption {
+	return func(o *writeOptions) { o.concurrency = concurrency }
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency == "" && o.etag != "" {
+		o.concurrency = FirstWrite
+	}
+	return o
+}
+
+// Op is a single operation of a state transaction, created with UpsertOp or DeleteOp
+type Op struct {
+	key    string
+	value  []byte
+	delete bool
+	opts   writeOptions
+	// related holds the operations that must run in the same transaction, such as the writes of the chunks
+	// of a value split by the store
+	related []Op
+}
+
+// expand returns the related operations followed by the operation itself, so a reader never finds a
+// manifest pointing to chunks that weren't written yet
+func (op Op) expand() []Op {
+	return append(op.related[:len(op.related):len(op.related)], op)
+}
+
+// Codec transforms the JSON encoding of values on their way to and from the state store, for example to encrypt them
+type Codec interface {
+	Encode(ctx context.Context, data []byte) ([]byte, error)
+	Decode(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// Store is a typed repository over a Dapr state store, which stores values of type T as JSON documents
+type Store[T any] interface {
+	// Get returns the value of a key, or ErrNotFound
+	Get(ctx context.Context, key string) (*Entry[T], error)
+	// Put saves the value of a key
+	Put(ctx context.Context, key string, value T, opts ...WriteOption) error
+	// Delete deletes a key
+	Delete(ctx context.Context, key string, opts ...WriteOption) error
+	// List returns the values of the keys in a single call, reporting failures per key in Entry.Err
+	List(ctx context.Context, keys []string) ([]*Entry[T], error)
+	// PutAll saves the entries, with their ETags and metadata, in a single call; values split into chunks are
+	// saved in a transaction each, with their manifest
+	PutAll(ctx context.Context, entries []*Entry[T]) error
+	// DeleteAll deletes the keys
+	DeleteAll(ctx context.Context, keys []string) error
+	// Query runs a state query and returns a page of results with the token for the next page
+	Query(ctx context.Context, query stateQuery, metadata map[string]string) ([]*Entry[T], string, error)
+	// UpsertOp creates a transaction operation that saves the value of a key
+	UpsertOp(ctx context.Context, key string, value T, opts ...WriteOption) (Op, error)
+	// DeleteOp creates a transaction operation that deletes a key
+	DeleteOp(ctx context.Context, key string, opts ...WriteOption) (Op, error)
+	// Transact runs the operations atomically; they may come from any store on the same component. The state
+	// store enforces the ETags of the operations, but the sidecar reports a stale ETag like any other failure, so
+	// it returns ErrTransactionFailed rather than ErrETagMismatch, after the retries of the resiliency policy of
+	// the component. Callers that handle conflicts compare the ETags with the current entries before transacting.
+	Transact(ctx context.Context, ops ...Op) error
+	// Component returns the type, version and features of the state store component
+	Component(ctx context.Context) (*ComponentInfo, error)
+}
+
+// ComponentInfo describes a component loaded by the sidecar, as reported by the metadata API
+type ComponentInfo struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type storeOptions struct {
+	codecs    []Codec
+	chunkSize int
+}
+
+// StoreOption configures how a store saves values
+type StoreOption func(*storeOptions)
+
+// WithCodec passes values through a codec; codecs are applied in order before values are saved,
+// and in reverse order after they are read
+func WithCodec(codec Codec) StoreOption {
+	return func(o *storeOptions) { o.codecs = append(o.codecs, codec) }
+}
+
+// WithChunkSize splits values larger than size bytes, after they pass through the codecs, into chunks
+// saved under their own keys, with a manifest under the key of the value
+func WithChunkSize(size int) StoreOption {
+	return func(o *storeOptions) { o.chunkSize = size }
+}
+
+// sdkStore implements Store on top of the Dapr Go SDK client
+type sdkStore[T any] struct {
+	client    dapr.Client
+	storeName string
+	storeOptions
+}
+
+// newSDKStore creates a store on the given state store component
+func newSDKStore[T any](client dapr.Client, storeName string, opts ...StoreOption) Store[T] {
+	s := &sdkStore[T]{client: client, storeName: storeName}
+	for _, opt := range opts {
+		opt(&s.storeOptions)
+	}
+	return s
+}
+
+func (s *sdkStore[T]) Get(ctx context.Context, key string) (*Entry[T], error) {
+	item, err := s.client.GetState(ctx, s.storeName, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(item.Value) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	entry := &Entry[T]{Key: key, ETag: item.Etag, Metadata: item.Metadata}
+	err = s.decode(ctx, key, item.Value, &entry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return entry, nil
+}
+
+func (s *sdkStore[T]) Put(ctx context.Context, key string, value T, opts ...WriteOption) error {
+	op, err := s.UpsertOp(ctx, key, value, opts...)
+	if err != nil {
+		return err
+	}
+	if len(op.related) > 0 {
+		// Save the chunks and the manifest atomically
+		err = s.checkETag(ctx, op)
+		if err != nil {
+			return err
+		}
+		return s.Transact(ctx, op)
+	}
+	return toStoreError(s.client.SaveBulkState(ctx, s.storeName, op.setStateItem()))
+}
+
+func (s *sdkStore[T]) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	op, err := s.DeleteOp(ctx, key, opts...)
+	if err != nil {
+		return err
+	}
+	if len(op.related) > 0 {
+		err = s.checkETag(ctx, op)
+		if err != nil {
+			return err
+		}
+		return s.Transact(ctx, op)
+	}
+	item := op.setStateItem()
+	return toStoreError(s.client.DeleteStateWithETag(ctx, s.storeName, key, item.Etag, item.Metadata, item.Options))
+}
+
+func (s *sdkStore[T]) List(ctx context.Context, keys []string) ([]*Entry[T], error) {
+	results, err := s.client.GetBulkState(ctx, s.storeName, keys, nil, int32(len(keys)))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*Entry[T], 0, len(results))
+	for _, result := range results {
+		entry := &Entry[T]{Key: result.Key, ETag: result.Etag, Metadata: result.Metadata}
+		switch {
+		case result.Error != "":
+			entry.Err = errors.New(result.Error)
+		case len(result.Value) == 0:
+			entry.Err = fmt.Errorf("%w: %s", ErrNotFound, result.Key)
+		default:
+			entry.Err = s.decode(ctx, result.Key, result.Value, &entry.Value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *sdkStore[T]) PutAll(ctx context.Context, entries []*Entry[T]) error {
+	items := make([]*dapr.SetStateItem, 0, len(entries))
+	var chunked []Op
+	for _, entry := range entries {
+		op, err := s.UpsertOp(ctx, entry.Key, entry.Value, WithETag(entry.ETag), WithMetadata(entry.Metadata))
+		if err != nil {
+			return err
+		}
+		if len(op.related) > 0 {
+			chunked = append(chunked, op)
+			continue
+		}
+		items = append(items, op.setStateItem())
+	}
+	if len(items) > 0 {
+		err := toStoreError(s.client.SaveBulkState(ctx, s.storeName, items...))
+		if err != nil {
+			return err
+		}
+	}
+	// Save each value split into chunks like Put does, in a transaction with its manifest and the deletes of
+	// the chunks left over from a larger previous value
+	for _, op := range chunked {
+		err := s.checkETag(ctx, op)
+		if err != nil {
+			return err
+		}
+		err = s.Transact(ctx, op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sdkStore[T]) DeleteAll(ctx context.Context, keys []string) error {
+	if s.chunkSize > 0 {
+		// Delete the chunks of the values too
+		counts, err := s.chunkCounts(ctx, keys)
+		if err != nil {
+			return err
+		}
+		for _, key := range slices.Clone(keys) {
+			for i := 0; i < counts[key]; i++ {
+				keys = append(keys, chunkKey(key, i))
+			}
+		}
+	}
+	return toStoreError(s.client.DeleteBulkState(ctx, s.storeName, keys, nil))
+}
+
+func (s *sdkStore[T]) Query(ctx context.Context, query stateQuery, metadata map[string]string) ([]*Entry[T], string, error) {
+	q, err := json.Marshal(query)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := s.client.QueryStateAlpha1(ctx, s.storeName, string(q), metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	entries := make([]*Entry[T], 0, len(res.Results))
+	for _, result := range res.Results {
+		entry := &Entry[T]{Key: result.Key, ETag: result.Etag}
+		if result.Error != "" {
+			entry.Err = errors.New(result.Error)
+		} else {
+			entry.Err = s.decode(ctx, result.Key, result.Value, &entry.Value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, res.Token, nil
+}
+
+func (s *sdkStore[T]) UpsertOp(ctx context.Context, key string, value T, opts ...WriteOption) (Op, error) {
+	data, err := s.encode(ctx, value)
+	if err != nil {
+		return Op{}, fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	op := Op{key: key, value: data, opts: newWriteOptions(opts)}
+	if s.chunkSize == 0 {
+		return op, nil
+	}
+	// Look up the chunks of the previous value, so the ones the new value doesn't overwrite are deleted
+	counts, err := s.chunkCounts(ctx, []string{key})
+	if err != nil {
+		return Op{}, err
+	}
+	return op.withChunks(s.chunkSize, counts[key], func(chunk []byte) ([]byte, error) { return chunk, nil })
+}
+
+func (s *sdkStore[T]) DeleteOp(ctx context.Context, key string, opts ...WriteOption) (Op, error) {
+	op := Op{key: key, delete: true, opts: newWriteOptions(opts)}
+	if s.chunkSize == 0 {
+		return op, nil
+	}
+	counts, err := s.chunkCounts(ctx, []string{key})
+	if err != nil {
+		return Op{}, err
+	}
+	return op.withChunkDeletes(counts[key]), nil
+}
+
+func (s *sdkStore[T]) Transact(ctx context.Context, ops ...Op) error {
+	operations := make([]*dapr.StateOperation, 0, len(ops))
+	for _, op := range ops {
+		for _, op := range op.expand() {
+			operation := &dapr.StateOperation{Type: dapr.StateOperationTypeUpsert, Item: op.setStateItem()}
+			if op.delete {
+				operation.Type = dapr.StateOperationTypeDelete
+			}
+			operations = append(operations, operation)
+		}
+	}
+	err := s.client.ExecuteStateTransaction(ctx, s.storeName, nil, operations)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTransactionFailed, err)
+	}
+	return nil
+}
+
+func (s *sdkStore[T]) Component(ctx context.Context) (*ComponentInfo, error) {
+	metadata, err := s.client.GetMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range metadata.RegisteredComponents {
+		if c.Name == s.storeName {
+			return &ComponentInfo{Name: c.Name, Type: c.Type, Version: c.Version, Capabilities: c.Capabilities}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: component %s", ErrNotFound, s.storeName)
+}
+
+func (s *sdkStore[T]) encode(ctx context.Context, value T) ([]byte, error) {
+	data, err := json.Marshal(value)
+	for _, codec := range s.codecs {
+		if err != nil {
+			break
+		}
+		data, err = codec.Encode(ctx, data)
+	}
+	return data, err
+}
+
+// checkETag returns ErrETagMismatch when the key of a conditional operation no longer has its ETag. Chunked
+// values are written in a transaction, which doesn't report stale ETags as such, so they are checked first.
+func (s *sdkStore[T]) checkETag(ctx context.Context, op Op) error {
+	if op.opts.etag == "" {
+		return nil
+	}
+	item, err := s.client.GetState(ctx, s.storeName, op.key, nil)
+	if err != nil {
+		return err
+	}
+	if item.Etag != op.opts.etag {
+		return fmt.Errorf("%w: %s", ErrETagMismatch, op.key)
+	}
+	return nil
+}
+
+// chunkCounts returns the number of chunks of the values that were split into chunks
+func (s *sdkStore[T]) chunkCounts(ctx context.Context, keys []string) (map[string]int, error) {
+	results, err := s.client.GetBulkState(ctx, s.storeName, keys, nil, int32(len(keys)))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, result := range results {
+		if m, ok := parseManifest(result.Value); ok {
+			counts[result.Key] = m.Chunks
+		}
+	}
+	return counts, nil
+}
+
+// unchunk returns the value saved under key, reassembling it when it was split into chunks
+func (s *sdkStore[T]) unchunk(ctx context.Context, key string, data []byte) ([]byte, error) {
+	m, ok := parseManifest(data)
+	if !ok {
+		return data, nil
+	}
+	results, err := s.client.GetBulkState(ctx, s.storeName, m.keys(key), nil, int32(m.Chunks))
+	if err != nil {
+		return nil, err
+	}
+	// The bulk API doesn't keep the order of the keys
+	values := make(map[string][]byte, len(results))
+	for _, result := range results {
+		values[result.Key] = result.Value
+	}
+	chunks := make([][]byte, 0, m.Chunks)
+	for _, k := range m.keys(key) {
+		if len(values[k]) > 0 {
+			chunks = append(chunks, values[k])
+		}
+	}
+	return m.join(key, chunks)
+}
+
+func (s *sdkStore[T]) decode(ctx context.Context, key string, data []byte, value *T) error {
+	data, err := s.unchunk(ctx, key, data)
+	for i := len(s.codecs) - 1; i >= 0 && err == nil; i-- {
+		data, err = s.codecs[i].Decode(ctx, data)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// setStateItem converts the operation to the SDK representation
+func (op Op) setStateItem() *dapr.SetStateItem {
+	item := &dapr.SetStateItem{Key: op.key, Value: op.value, Metadata: op.opts.metadata}
+	if op.opts.etag != "" {
+		item.Etag = &dapr.ETag{Value: op.opts.etag}
+	}
+	if op.opts.concurrency != "" {
+		item.Options = &dapr.StateOptions{Consistency: dapr.StateConsistencyStrong, Concurrency: dapr.StateConcurrencyLastWrite}
+		if op.opts.concurrency == FirstWrite {
+			item.Options.Concurrency = dapr.StateConcurrencyFirstWrite
+		}
+	}
+	return item
+}
+
+// toStoreError reports the Aborted status returned by the sidecar for stale ETags as ErrETagMismatch
+func toStoreError(err error) error {
+	if status.Code(err) == codes.Aborted {
+		return fmt.Errorf("%w: %w", ErrETagMismatch, err)
+	}
+	return err
+}
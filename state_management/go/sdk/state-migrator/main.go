@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+const usage = `Usage:
+  state-migrator copy -from <store> -to <store> [key options]
+  state-migrator dump -from <store> [-out <file>] [key options]
+  state-migrator restore -to <store> [-in <file>]
+
+The -keys, -keys-file and -prefix options select the keys to read; without them every key of the store
+is read with the query API.
+
+Options:
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "name of the state store component to read from")
+	to := fs.String("to", "", "name of the state store component to write to")
+	out := fs.String("out", "", "NDJSON file to dump to (default stdout)")
+	in := fs.String("in", "", "NDJSON file to restore from (default stdin)")
+	batchSize := fs.Int("batch", 100, "number of keys per bulk call")
+	keys := newKeyFlags(fs)
+	fs.Parse(os.Args[2:])
+	if *batchSize <= 0 {
+		log.Fatalf("Invalid value for -batch: %d", *batchSize)
+	}
+
+	client, err := dapr.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	switch fs.Name() {
+	case "copy":
+		requireFlag("from", *from)
+		requireFlag("to", *to)
+		writer := &storeWriter{client: client, storeName: *to}
+		n := readStore(ctx, client, *from, keys, *batchSize, writer.write)
+		fmt.Fprintf(os.Stderr, "Copied %d keys from %s to %s\n", n, *from, *to)
+	case "dump":
+		requireFlag("from", *from)
+		w := os.Stdout
+		if *out != "" {
+			w, err = os.Create(*out)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		writer := newDumpWriter(w)
+		n := readStore(ctx, client, *from, keys, *batchSize, writer.write)
+		err = writer.close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Dumped %d keys from %s\n", n, *from)
+	case "restore":
+		requireFlag("to", *to)
+		r := os.Stdin
+		if *in != "" {
+			r, err = os.Open(*in)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer r.Close()
+		}
+		writer := &storeWriter{client: client, storeName: *to}
+		n := restore(ctx, r, *batchSize, writer.write)
+		fmt.Fprintf(os.Stderr, "Restored %d keys to %s\n", n, *to)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// keyFlags selects the keys to read from the source store
+type keyFlags struct {
+	keys       *string
+	keysFile   *string
+	prefix     *string
+	queryIndex *string
+}
+
+func newKeyFlags(fs *flag.FlagSet) *keyFlags {
+	return &keyFlags{
+		keys:       fs.String("keys", "", "comma-separated list of keys to read"),
+		keysFile:   fs.String("keys-file", "", "file with one key per line to read"),
+		prefix:     fs.String("prefix", "", "read the keys that start with this prefix, found with the query API"),
+		queryIndex: fs.String("query-index", "", "name of the query index, required by the query API of some stores such as Redis"),
+	}
+}
+
+// explicit returns the keys given on the command line or in the keys file, or nil when the keys must be listed
+func (k *keyFlags) explicit() []string {
+	var keys []string
+	if *k.keys != "" {
+		keys = strings.Split(*k.keys, ",")
+	}
+	if *k.keysFile != "" {
+		f, err := os.Open(*k.keysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if key := strings.TrimSpace(scanner.Text()); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func requireFlag(name, value string) {
+	if value == "" {
+		log.Fatalf("Missing required flag -%s", name)
+	}
+}
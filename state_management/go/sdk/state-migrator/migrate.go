@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+// record is a key with its value and the metadata returned by the source store
+type record struct {
+	Key string `json:"key"`
+	// Value holds JSON values as they are, so dumps stay readable
+	Value json.RawMessage `json:"value,omitempty"`
+	// Data holds values that aren't JSON, base64-encoded
+	Data     []byte            `json:"data,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func newRecord(item *dapr.BulkStateItem) *record {
+	r := &record{Key: item.Key, Metadata: item.Metadata}
+	if isCompactJSON(item.Value) {
+		r.Value = item.Value
+	} else {
+		r.Data = item.Value
+	}
+	return r
+}
+
+// isCompactJSON reports whether a value is JSON without insignificant whitespace, which the NDJSON encoder
+// writes as it is; other values are base64-encoded so they are restored byte for byte
+func isCompactJSON(value []byte) bool {
+	var buf bytes.Buffer
+	return json.Compact(&buf, value) == nil && bytes.Equal(buf.Bytes(), value)
+}
+
+func (r *record) value() []byte {
+	if r.Value != nil {
+		return r.Value
+	}
+	return r.Data
+}
+
+// readStore reads the selected keys from the store in batches and passes the records to write,
+// returning the number of records written
+func readStore(ctx context.Context, client dapr.Client, storeName string, keys *keyFlags, batchSize int, write func(context.Context, []*record) (int, error)) int {
+	selected := keys.explicit()
+	if selected == nil {
+		selected = listKeys(ctx, client, storeName, *keys.prefix, *keys.queryIndex, batchSize)
+	}
+
+	count := 0
+	for start := 0; start < len(selected); start += batchSize {
+		batch := selected[start:min(start+batchSize, len(selected))]
+		items, err := client.GetBulkState(ctx, storeName, batch, nil, int32(len(batch)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		records := make([]*record, 0, len(items))
+		for _, item := range items {
+			switch {
+			case item.Error != "":
+				fmt.Fprintf(os.Stderr, "Failed to read %s: %s\n", item.Key, item.Error)
+			case len(item.Value) == 0:
+				fmt.Fprintf(os.Stderr, "Skipped %s: key not found\n", item.Key)
+			default:
+				records = append(records, newRecord(item))
+			}
+		}
+		n, err := write(ctx, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+		count += n
+	}
+	return count
+}
+
+// listKeys pages through the whole store with the query API and returns the keys that start with prefix;
+// stores without query support can only be read with an explicit list of keys
+func listKeys(ctx context.Context, client dapr.Client, storeName, prefix, queryIndex string, pageSize int) []string {
+	metadata := map[string]string{"contentType": "application/json"}
+	if queryIndex != "" {
+		metadata["queryIndexName"] = queryIndex
+	}
+	var keys []string
+	token := ""
+	for {
+		query, err := json.Marshal(map[string]any{"page": map[string]any{"limit": pageSize, "token": token}})
+		if err != nil {
+			log.Fatal(err)
+		}
+		res, err := client.QueryStateAlpha1(ctx, storeName, string(query), metadata)
+		if err != nil {
+			log.Fatalf("Failed to list the keys of %s, pass them with -keys or -keys-file instead: %v", storeName, err)
+		}
+		for _, result := range res.Results {
+			if strings.HasPrefix(result.Key, prefix) {
+				keys = append(keys, result.Key)
+			}
+		}
+		if res.Token == "" || len(res.Results) == 0 {
+			return keys
+		}
+		token = res.Token
+	}
+}
+
+// storeWriter saves records to a state store
+type storeWriter struct {
+	client    dapr.Client
+	storeName string
+}
+
+// write saves the records that haven't expired, returning how many were saved
+func (w *storeWriter) write(ctx context.Context, records []*record) (int, error) {
+	items := make([]*dapr.SetStateItem, 0, len(records))
+	now := time.Now()
+	for _, r := range records {
+		metadata, expired := writeMetadata(r.Metadata, now)
+		if expired {
+			fmt.Fprintf(os.Stderr, "Skipped %s: expired\n", r.Key)
+			continue
+		}
+		items = append(items, &dapr.SetStateItem{Key: r.Key, Value: r.value(), Metadata: metadata})
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	return len(items), w.client.SaveBulkState(ctx, w.storeName, items...)
+}
+
+// writeMetadata converts the metadata returned with a value into metadata the target store accepts:
+// the content type is kept and the expiry time becomes the remaining time to live. ETags are specific to
+// a store and aren't carried over.
+func writeMetadata(read map[string]string, now time.Time) (map[string]string, bool) {
+	metadata := make(map[string]string)
+	if contentType := read["contentType"]; contentType != "" {
+		metadata["contentType"] = contentType
+	}
+	if expireTime := read["ttlExpireTime"]; expireTime != "" {
+		expiry, err := time.Parse(time.RFC3339, expireTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring invalid ttlExpireTime %q: %v\n", expireTime, err)
+			return metadata, false
+		}
+		ttl := math.Ceil(expiry.Sub(now).Seconds())
+		if ttl <= 0 {
+			return nil, true
+		}
+		metadata["ttlInSeconds"] = strconv.Itoa(int(ttl))
+	}
+	return metadata, false
+}
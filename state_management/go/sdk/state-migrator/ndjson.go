@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+// dumpWriter writes records as NDJSON, one record per line
+type dumpWriter struct {
+	w   *bufio.Writer
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newDumpWriter(f *os.File) *dumpWriter {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	// Keep the values byte for byte, instead of escaping <, > and & in them
+	enc.SetEscapeHTML(false)
+	return &dumpWriter{w: w, f: f, enc: enc}
+}
+
+func (d *dumpWriter) write(_ context.Context, records []*record) (int, error) {
+	for i, r := range records {
+		err := d.enc.Encode(r)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
+
+func (d *dumpWriter) close() error {
+	err := d.w.Flush()
+	if err != nil {
+		return err
+	}
+	if d.f == os.Stdout {
+		return nil
+	}
+	return d.f.Close()
+}
+
+// restore reads the NDJSON records written by dump and passes them to write in batches,
+// returning the number of records written
+func restore(ctx context.Context, r io.Reader, batchSize int, write func(context.Context, []*record) (int, error)) int {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	count := 0
+	batch := make([]*record, 0, batchSize)
+	flush := func() {
+		n, err := write(ctx, batch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		count += n
+		batch = batch[:0]
+	}
+	for {
+		var rec record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Invalid record in the dump: %v", err)
+		}
+		batch = append(batch, &rec)
+		if len(batch) == batchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+	return count
+}